@@ -1,7 +1,14 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
 	"github.com/AlexxIT/SmartScaleConnect/pkg/garmin"
@@ -19,61 +26,293 @@ const (
 	AccXiaomi     = "xiaomi"
 	AccXiaomiHome = "xiaomihome"
 	AccZeppXiaomi = "zepp/xiaomi"
+	AccZeppEmail  = "zepp/email"
+	AccZeppHuami  = "zepp/huami"
 )
 
-var accounts = map[string]core.Account{}
+type account struct {
+	core.Account
+	password string
+}
+
+var (
+	accounts   = map[string]*account{}
+	accountsMu sync.Mutex
+)
 
-func GetAccount(fields []string) (core.Account, error) {
-	key := fields[0] + ":" + fields[1]
-	if account, ok := accounts[key]; ok {
-		return account, nil
+// GetAccount returns authorized account. Syncs with same account shouldn't run in parallel.
+func GetAccount(ctx context.Context, fields []string) (core.Account, error) {
+	key := accountKey(fields)
+
+	var password string
+	if len(fields) > 2 {
+		password = fields[2]
 	}
 
-	account, err := getAccount(fields, key)
+	accountsMu.Lock()
+	cached, ok := accounts[key]
+	accountsMu.Unlock()
+
+	// password may be changed in config
+	if ok && cached.password == password {
+		return cached.Account, nil
+	}
+
+	acc, err := getAccount(ctx, fields, key)
 	if err != nil {
-		return nil, err
+		return nil, &accountError{key: key, err: err}
+	}
+
+	accountsMu.Lock()
+	accounts[key] = &account{Account: acc, password: password}
+	accountsMu.Unlock()
+
+	return acc, nil
+}
+
+// KeepAccounts removes authorized accounts that are no longer used in syncs
+func KeepAccounts(syncs []*Sync) {
+	used := map[string]bool{}
+	for _, sync := range syncs {
+		for _, key := range sync.Accounts() {
+			used[key] = true
+		}
+	}
+
+	accountsMu.Lock()
+	for key := range accounts {
+		if !used[key] {
+			delete(accounts, key)
+		}
+	}
+	accountsMu.Unlock()
+}
+
+// DropAccounts removes authorized accounts, so they will login again on next use
+func DropAccounts(keys []string) {
+	accountsMu.Lock()
+	for _, key := range keys {
+		delete(accounts, key)
+	}
+	accountsMu.Unlock()
+}
+
+// accountKey - unique key for accounts cache, tokens file and locks
+func accountKey(fields []string) string {
+	if fields[0] == AccGarmin {
+		if region, _, _ := garminOptions(fields); region != "" {
+			return AccGarmin + "/" + region + ":" + fields[1]
+		}
+	}
+	return fields[0] + ":" + fields[1]
+}
+
+// garminOptions returns optional region and TOTP secret after password: cn totp={secret}
+func garminOptions(fields []string) (region, secret string, err error) {
+	for _, s := range fields[min(3, len(fields)):] {
+		if s == "cn" {
+			region = s
+		} else if v, ok := strings.CutPrefix(s, "totp="); ok && v != "" {
+			secret = v
+		} else {
+			return "", "", errors.New("garmin: unknown option: " + s + " (supported: cn, totp={secret})")
+		}
+	}
+	return
+}
+
+// xiaomiChallenges asks captcha and verification code from user
+func xiaomiChallenges(key string) xiaomi.Challenges {
+	return xiaomi.Challenges{
+		Captcha: func(ctx context.Context, image []byte) (string, error) {
+			f, err := os.CreateTemp(filepath.Join(tokensDir, "."), "captcha_*.jpg")
+			if err != nil {
+				return "", err
+			}
+			defer os.Remove(f.Name())
+
+			_, err = f.Write(image)
+			if err1 := f.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return "", err
+			}
+			return AskCode(ctx, key, "enter Xiaomi captcha from "+f.Name())
+		},
+		Code: func(ctx context.Context, message string) (string, error) {
+			return AskCode(ctx, key, message)
+		},
 	}
+}
 
-	accounts[key] = account
+func init() {
+	xiaomi.UnknownScale = saveUnknownScale
+	xiaomi.Warn = func(err error) {
+		log.Printf("%v\n", err)
+	}
+}
+
+var unknownScales sync.Map
+
+// saveUnknownScale saves raw data of unknown scales once, so support can be added later
+func saveUnknownScale(model string, raw []byte) {
+	if _, loaded := unknownScales.LoadOrStore(model, true); loaded {
+		return
+	}
+
+	name := filepath.Join(tokensDir, "xiaomi_"+strings.Map(safeRune, model)+".json")
+	if err := os.WriteFile(name, raw, 0600); err != nil {
+		log.Printf("xiaomi: unknown scales %s: %v\n", model, err)
+		return
+	}
 
-	return account, nil
+	log.Printf("xiaomi: unknown scales %s, raw data saved to %s\n", model, name)
 }
 
-func getAccount(fields []string, key string) (core.Account, error) {
+func isAccount(name string) bool {
+	switch name {
+	case AccGarmin, AccMiFitness, AccPicooc, AccTanita, AccXiaomi, AccXiaomiHome, AccZeppXiaomi, AccZeppEmail, AccZeppHuami:
+		return true
+	}
+	return false
+}
+
+func getAccount(ctx context.Context, fields []string, key string) (core.Account, error) {
 	var acc core.Account
 
 	switch fields[0] {
 	case AccGarmin:
-		acc = garmin.NewClient()
+		region, secret, err := garminOptions(fields)
+		if err != nil {
+			return nil, err
+		}
+		client := garmin.NewClient(region)
+		client.MFA = func(ctx context.Context) (string, error) {
+			if secret != "" {
+				return core.TOTP(secret, time.Now())
+			}
+			return AskCode(ctx, key, "enter Garmin MFA code")
+		}
+		acc = client
 	case AccPicooc:
 		acc = picooc.NewClient()
 	case AccTanita:
 		acc = tanita.NewClient()
 	case AccXiaomi, AccMiFitness:
-		acc = xiaomi.NewClient(xiaomi.AppMiFitness)
+		client := xiaomi.NewClient(xiaomi.AppMiFitness)
+		client.Challenges = xiaomiChallenges(key)
+		acc = client
 	case AccXiaomiHome:
-		acc = xiaomi.NewClient(xiaomi.AppXiaomiHome)
+		client := xiaomi.NewClient(xiaomi.AppXiaomiHome)
+		client.Challenges = xiaomiChallenges(key)
+		acc = client
 	case AccZeppXiaomi:
-		acc = zepp.NewClient()
+		client := zepp.NewClient(zepp.LoginXiaomi)
+		client.Xiaomi = xiaomiChallenges(key)
+		acc = client
+	case AccZeppEmail, AccZeppHuami:
+		acc = zepp.NewClient(zepp.LoginHuami)
 	default:
 		return nil, errors.New("unsupported type: " + fields[0])
 	}
 
+	if err := login(ctx, acc, fields, key, LoadToken(key)); err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+// login tries token first (if not empty) and then credentials
+func login(ctx context.Context, acc core.Account, fields []string, key string, token string) error {
 	if acc, ok := acc.(core.AccountWithToken); ok {
-		if token := LoadToken(key); token != "" {
-			if err := acc.LoginWithToken(token); err == nil {
-				return acc, nil
+		if token != "" {
+			if err := acc.LoginWithToken(ctx, token); err == nil {
+				// token may be refreshed during login
+				if newToken := acc.Token(); newToken != token {
+					if err = SaveToken(key, newToken); err != nil {
+						log.Printf("%s: %v\n", key, err)
+					}
+				}
+				return nil
 			}
 		}
 	}
 
-	if err := acc.Login(fields[1], fields[2]); err != nil {
-		return nil, err
+	if len(fields) < 3 {
+		return errors.New(key + ": password required")
+	}
+
+	if err := acc.Login(ctx, fields[1], fields[2]); err != nil {
+		return err
 	}
 
 	if acc, ok := acc.(core.AccountWithToken); ok {
-		SaveToken(key, acc.Token())
+		if err := SaveToken(key, acc.Token()); err != nil {
+			log.Printf("%s: %v\n", key, err)
+		}
 	}
 
-	return acc, nil
+	return nil
+}
+
+// withAccount runs fn with authorized account. If session expires,
+// it login again and repeats fn once.
+func withAccount(ctx context.Context, fields []string, fn func(acc core.Account) error) error {
+	acc, err := GetAccount(ctx, fields)
+	if err != nil {
+		return err
+	}
+
+	key := accountKey(fields)
+
+	if err = fn(acc); !errors.Is(err, core.ErrUnauthorized) {
+		return wrapAccountError(key, err)
+	}
+
+	log.Printf("%s: %v, login again\n", key, err)
+
+	// long-lived token (Xiaomi passToken, Zepp login token) can renew short session
+	var token string
+	if acc, ok := acc.(core.AccountWithToken); ok {
+		token = acc.Token()
+	}
+
+	if err = login(ctx, acc, fields, key, token); err != nil {
+		DropAccounts([]string{key})
+		return &accountError{key: key, err: err}
+	}
+
+	return wrapAccountError(key, fn(acc))
+}
+
+// accountError - error of the account, so only this account is paused after many failures
+type accountError struct {
+	key string
+	err error
+}
+
+func (e *accountError) Error() string {
+	return e.err.Error()
+}
+
+func (e *accountError) Unwrap() error {
+	return e.err
+}
+
+func wrapAccountError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &accountError{key: key, err: err}
+}
+
+// errorAccount returns key of the account that returned the error
+func errorAccount(err error) string {
+	var accErr *accountError
+	if errors.As(err, &accErr) {
+		return accErr.key
+	}
+	return ""
 }
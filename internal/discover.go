@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+	"github.com/AlexxIT/SmartScaleConnect/pkg/xiaomi"
+)
+
+// scalesTTL - found scales are saved near the account token, so auto mode doesn't search all regions each run
+const scalesTTL = 24 * time.Hour
+
+// Discover returns Xiaomi scales from all regions: xiaomi {username} {password}
+func Discover(ctx context.Context, fields []string) ([]*xiaomi.Device, error) {
+	if len(fields) < 3 || fields[0] != AccXiaomi {
+		return nil, errors.New("usage: discover xiaomi {username} {password}")
+	}
+
+	homeFields := xiaomiHomeFields(fields)
+
+	var scales []*xiaomi.Device
+	var errs []error
+
+	// one login for all regions, failed regions are skipped
+	err := withAccount(ctx, homeFields, func(acc core.Account) error {
+		scales, errs = nil, nil
+
+		for _, region := range xiaomi.Regions {
+			devices, err := acc.(*xiaomi.Client).GetDevices(ctx, region)
+			if err != nil {
+				// session expired, search again after login
+				if errors.Is(err, core.ErrUnauthorized) {
+					return err
+				}
+				log.Printf("xiaomi: can't get devices from region %s: %v\n", region, err)
+				errs = append(errs, err)
+				continue
+			}
+
+			for _, device := range devices {
+				if strings.Contains(device.Model, ".scales.") {
+					scales = append(scales, device)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(errs) == len(xiaomi.Regions) {
+		return nil, errors.Join(errs...)
+	}
+
+	// scales from failed region can be lost, and new scales can be added, so search again next time
+	if len(errs) == 0 && len(scales) > 0 {
+		saveScales(accountKey(homeFields), scales)
+	}
+
+	return scales, nil
+}
+
+// getXiaomiAuto loads weighings from all found scales: xiaomi {username} {password} auto {user}
+func getXiaomiAuto(ctx context.Context, fields []string) ([]*core.Weight, error) {
+	key := accountKey(xiaomiHomeFields(fields))
+
+	scales := loadScales(key)
+	if scales == nil {
+		var err error
+		if scales, err = Discover(ctx, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(scales) == 0 {
+		return nil, errors.New("xiaomi: can't find scales")
+	}
+
+	user := strings.Join(fields[4:], " ")
+
+	var weights []*core.Weight
+	var done []string
+
+	for _, scale := range scales {
+		// one request returns data from all scales with same model
+		key := scale.Region + "/" + scale.Model
+		if slices.Contains(done, key) {
+			continue
+		}
+		done = append(done, key)
+
+		var items []*core.Weight
+		var err error
+
+		if scale.Region == "cn" {
+			// China scales works better with Mi Fitness
+			err = withAccount(ctx, fields[:3], func(acc core.Account) (err error) {
+				items, err = acc.(*xiaomi.Client).GetScaleWeights(ctx, scale.Model, user)
+				return
+			})
+		} else {
+			err = withAccount(ctx, xiaomiHomeFields(fields), func(acc core.Account) (err error) {
+				items, err = acc.(*xiaomi.Client).GetModelWeights(ctx, scale.Region, scale.Model, user)
+				return
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		weights = append(weights, items...)
+	}
+
+	return weights, nil
+}
+
+// loadScales returns saved scales (region/model list), nil if they are missing or too old
+func loadScales(key string) []*xiaomi.Device {
+	ts, _ := strconv.ParseInt(LoadMeta(key, "scales_time"), 10, 64)
+	if time.Since(time.Unix(ts, 0)) > scalesTTL {
+		return nil
+	}
+
+	var scales []*xiaomi.Device
+	for _, s := range strings.Fields(LoadMeta(key, "scales")) {
+		region, model, _ := strings.Cut(s, "/")
+		scales = append(scales, &xiaomi.Device{Region: region, Model: model})
+	}
+	return scales
+}
+
+func saveScales(key string, scales []*xiaomi.Device) {
+	var items []string
+	for _, scale := range scales {
+		items = append(items, scale.Region+"/"+scale.Model)
+	}
+
+	err := SaveMeta(key, "scales", strings.Join(items, " "))
+	if err == nil {
+		err = SaveMeta(key, "scales_time", strconv.FormatInt(time.Now().Unix(), 10))
+	}
+	if err != nil {
+		log.Printf("%s: %v\n", key, err)
+	}
+}
+
+// xiaomiHomeFields - device list works only with Xiaomi Home session, token is same for all Xiaomi apps
+func xiaomiHomeFields(fields []string) []string {
+	fields = slices.Clone(fields[:3])
+	fields[0] = AccXiaomiHome
+	return fields
+}
@@ -19,11 +19,11 @@ func Expr(config map[string]string, weights []*core.Weight) error {
 		switch key {
 		case "Date":
 			opt = expr.AsAny()
-		case "Weight", "BMI", "BodyFat", "BodyWater", "BoneMass", "MuscleMass", "ProteinMass", "Height", "SkeletalMuscleMass":
+		case "Weight", "BMI", "BodyFat", "BodyWater", "BoneMass", "MuscleMass", "ProteinMass", "Height", "SkeletalMuscleMass", "Impedance", "ImpedanceHigh":
 			opt = expr.AsFloat64()
 		case "MetabolicAge", "PhysiqueRating", "VisceralFat", "BasalMetabolism", "BodyScore", "HeartRate":
 			opt = expr.AsInt()
-		case "User", "Source":
+		case "User", "Source", "Zone":
 			opt = expr.AsKind(reflect.String)
 		}
 
@@ -79,10 +79,16 @@ func Expr(config map[string]string, weights []*core.Weight) error {
 				weight.Height = float32(v.(float64))
 			case "SkeletalMuscleMass":
 				weight.SkeletalMuscleMass = float32(v.(float64))
+			case "Impedance":
+				weight.Impedance = float32(v.(float64))
+			case "ImpedanceHigh":
+				weight.ImpedanceHigh = float32(v.(float64))
 			case "User":
 				weight.User = v.(string)
 			case "Source":
 				weight.Source = v.(string)
+			case "Zone":
+				weight.Zone = v.(string)
 			}
 		}
 	}
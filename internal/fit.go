@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+	"github.com/AlexxIT/SmartScaleConnect/pkg/garmin/fit"
+)
+
+// readFit reads FIT file or all FIT files from folder
+func readFit(path string) ([]*core.Weight, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return readFitFile(path)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var weights []*core.Weight
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".fit") {
+			continue
+		}
+
+		items, err := readFitFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		weights = append(weights, items...)
+	}
+	return weights, nil
+}
+
+func readFitFile(path string) ([]*core.Weight, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return fit.ReadWeight(f)
+}
+
+// writeFit - format: fit {path} [user] [{records per file}]
+func writeFit(config string, src []*core.Weight) error {
+	fields := strings.Fields(config)
+	path := fields[1]
+
+	var perUser bool
+	var perFile int
+
+	for _, s := range fields[2:] {
+		if s == "user" {
+			perUser = true
+		} else if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			perFile = n
+		} else {
+			return errors.New("fit: unknown option: " + s)
+		}
+	}
+
+	// single file is updated like CSV file
+	if !perUser && perFile == 0 {
+		// empty dst file is OK
+		dst, _ := readFit(path)
+		return writeFitFile(path, appendFile(dst, src))
+	}
+
+	// multiple files are fully rewritten every time
+	weights := prepareFile(src)
+
+	users := map[string][]*core.Weight{}
+	for _, w := range weights {
+		var user string
+		if perUser {
+			user = w.User
+		}
+		users[user] = append(users[user], w)
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	// files from previous runs (old chunks, removed users)
+	old, _ := filepath.Glob(base + "_*.fit")
+
+	for user, items := range users {
+		name := base
+		if user != "" {
+			name += "_" + strings.Map(safeRune, user)
+		}
+
+		if perFile == 0 {
+			old = slices.DeleteFunc(old, isPath(name+".fit"))
+			if err := writeFitFile(name+".fit", items); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for i, chunk := range slices.Collect(slices.Chunk(items, perFile)) {
+			name := fmt.Sprintf("%s_%d.fit", name, i+1)
+			old = slices.DeleteFunc(old, isPath(name))
+			if err := writeFitFile(name, chunk); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range old {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isPath(path string) func(string) bool {
+	return func(s string) bool { return filepath.Clean(s) == filepath.Clean(path) }
+}
+
+func writeFitFile(path string, weights []*core.Weight) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return fit.WriteWeight(f, weights...)
+}
+
+// safeRune replaces symbols that are not allowed in file names
+func safeRune(r rune) rune {
+	if strings.ContainsRune(`/\:*?"<>| `, r) {
+		return '_'
+	}
+	return r
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const promptTimeout = 5 * time.Minute
+
+// Prompt - JSON line to stdout, when app waits one-time code from user
+type Prompt struct {
+	Prompt  string `json:"prompt"` // account key
+	Message string `json:"message"`
+}
+
+var (
+	prompts     = map[string]chan string{}
+	promptsMu   sync.Mutex
+	interactive bool
+	terminal    sync.Once
+)
+
+// SetInteractive - stdin is processed by app, so codes are received with AnswerPrompt
+func SetInteractive(v bool) {
+	interactive = v
+}
+
+// AskCode waits one-time code from terminal or from stdin protocol
+func AskCode(ctx context.Context, key, message string) (string, error) {
+	ch := make(chan string, 1)
+
+	promptsMu.Lock()
+	if _, ok := prompts[key]; ok {
+		promptsMu.Unlock()
+		return "", errors.New(key + ": code already requested")
+	}
+	prompts[key] = ch
+	promptsMu.Unlock()
+
+	defer func() {
+		promptsMu.Lock()
+		if prompts[key] == ch {
+			delete(prompts, key)
+		}
+		promptsMu.Unlock()
+	}()
+
+	if interactive {
+		stdoutMu.Lock()
+		_ = json.NewEncoder(os.Stdout).Encode(&Prompt{Prompt: key, Message: message})
+		stdoutMu.Unlock()
+	} else {
+		if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return "", errors.New(key + ": " + message + ": run app in terminal or in interactive mode")
+		}
+
+		terminal.Do(func() {
+			go readTerminal()
+		})
+	}
+
+	log.Printf("%s: %s\n", key, message)
+
+	// other syncs can use the worker while user enters the code
+	if w := getWorker(ctx); w != nil {
+		w.free()
+		defer w.take(ctx)
+	}
+
+	select {
+	case code := <-ch:
+		if code = strings.TrimSpace(code); code == "" {
+			return "", errors.New(key + ": empty code")
+		}
+		return code, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(promptTimeout):
+		return "", errors.New(key + ": code timeout")
+	}
+}
+
+// readTerminal - single stdin reader for all prompts, so a timed out prompt doesn't steal next line.
+// Line format: {code} or {account} {code}, if several prompts wait.
+func readTerminal() {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+
+		var key string
+		if i := strings.LastIndexByte(line, ' '); i > 0 {
+			key, line = line[:i], line[i+1:]
+		}
+
+		if !AnswerPrompt(key, line) {
+			log.Printf("stdin: no waiting prompt\n")
+		}
+	}
+}
+
+// AnswerPrompt sends code to waiting prompt. Empty key is OK if only one prompt waits.
+func AnswerPrompt(key, code string) bool {
+	promptsMu.Lock()
+	defer promptsMu.Unlock()
+
+	if key == "" && len(prompts) == 1 {
+		for k := range prompts {
+			key = k
+		}
+	}
+
+	ch, ok := prompts[key]
+	if !ok {
+		return false
+	}
+
+	// only one answer for each prompt
+	delete(prompts, key)
+	ch <- code
+	return true
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Request - JSON line from stdin with config or command (run sync_name, list, status)
+type Request struct {
+	ID      json.RawMessage `json:"id"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	Command string          `json:"command,omitempty"`
+}
+
+type Response struct {
+	ID      json.RawMessage `json:"id"`
+	Results []*Result       `json:"results,omitempty"`
+	Syncs   []*SyncInfo     `json:"syncs,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type SyncInfo struct {
+	Name      string   `json:"name"`
+	Schedule  string   `json:"schedule,omitempty"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// ParseRequest returns nil if line is not a protocol request (JSON line with id field).
+// Wrong JSON line returns error and request with id if it can be read, so client gets response.
+func ParseRequest(data []byte) (*Request, error) {
+	if data = bytes.TrimSpace(data); len(data) == 0 || data[0] != '{' {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// not JSON, maybe flow style YAML config
+		if id := readID(data); id != nil {
+			return &Request{ID: id}, err
+		}
+		return nil, nil
+	}
+
+	// JSON config without id
+	id, ok := fields["id"]
+	if !ok {
+		return nil, nil
+	}
+
+	req := &Request{}
+	err := json.Unmarshal(data, req)
+	req.ID = id
+	return req, err
+}
+
+// readID reads id from the beginning of broken JSON line
+func readID(data []byte) json.RawMessage {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return nil
+		}
+
+		if key == "id" {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// ParseConfig - config can be JSON object or YAML string
+func (r *Request) ParseConfig() ([]*Sync, error) {
+	if r.Config == nil {
+		return nil, errors.New("empty config")
+	}
+
+	data := []byte(r.Config)
+
+	if data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, err
+		}
+		data = []byte(s)
+	}
+
+	return ParseSyncs(data)
+}
+
+// ParseCommand returns command name and its arguments
+func (r *Request) ParseCommand() (string, []string) {
+	fields := strings.Fields(r.Command)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+func ListSyncs(syncs []*Sync) []*SyncInfo {
+	items := make([]*SyncInfo, 0, len(syncs))
+	for _, sync := range syncs {
+		info := &SyncInfo{Name: sync.Name, DependsOn: sync.DependsOn}
+		if sync.Schedule != nil {
+			info.Schedule = sync.Schedule.String()
+		}
+		items = append(items, info)
+	}
+	return items
+}
+
+var stdoutMu sync.Mutex
+
+// WriteResponse writes response as single JSON line to stdout
+func WriteResponse(res *Response) {
+	stdoutMu.Lock()
+	_ = json.NewEncoder(os.Stdout).Encode(res)
+	stdoutMu.Unlock()
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+)
+
+const (
+	retryCount = 3
+	retryDelay = 10 * time.Second // doubles after each retry
+
+	breakerFailures = 5         // consecutive failed syncs for the account
+	breakerTimeout  = time.Hour // account pause after too many failures
+)
+
+// runWithRetry retries sync on network and server errors and pauses accounts after many failures
+func runWithRetry(ctx context.Context, sync *Sync, res *Result, capture bool) error {
+	keys := sync.Accounts()
+
+	if err := checkBreaker(keys); err != nil {
+		return err
+	}
+
+	delay := retryDelay
+
+	for i := 0; ; i++ {
+		err := runSafe(ctx, sync, res, capture)
+		if err == nil {
+			resetBreaker(keys)
+			return nil
+		}
+
+		// auth errors are not retried, withAccount has already logged in again
+		if i == retryCount || !core.IsTransient(err) {
+			// only the failed account, other accounts of the sync are OK
+			if key := errorAccount(err); key != "" && ctx.Err() == nil {
+				failBreaker(key)
+			}
+			return err
+		}
+
+		log.Printf("%s: %v, retry in %s\n", sync.Name, err, delay)
+
+		// other syncs can use the worker while this one waits
+		w := getWorker(ctx)
+		w.free()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+
+		if err = w.take(ctx); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// runSafe - any panic inside sync shouldn't stop the app
+func runSafe(ctx context.Context, sync *Sync, res *Result, capture bool) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return sync.Run(ctx, res, capture)
+}
+
+type breaker struct {
+	failures int
+	until    time.Time
+}
+
+var (
+	breakers   = map[string]*breaker{}
+	breakersMu sync.Mutex
+)
+
+func checkBreaker(keys []string) error {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	for _, key := range keys {
+		if b := breakers[key]; b != nil && time.Now().Before(b.until) {
+			return fmt.Errorf("account %s paused until %s after %d failures", key, b.until.Format(time.DateTime), b.failures)
+		}
+	}
+	return nil
+}
+
+func failBreaker(key string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	b := breakers[key]
+	if b == nil {
+		b = &breaker{}
+		breakers[key] = b
+	}
+	if b.failures++; b.failures >= breakerFailures {
+		b.until = time.Now().Add(breakerTimeout)
+		// account will login again after pause
+		DropAccounts([]string{key})
+	}
+}
+
+func resetBreaker(keys []string) {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	for _, key := range keys {
+		delete(breakers, key)
+	}
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schedule can be interval (15m, @every 15m) or cron expression (*/15 * * * *, @daily)
+type Schedule struct {
+	raw    string
+	every  time.Duration
+	fields [5]uint64 // minute, hour, day of month, month, day of week
+}
+
+func ParseSchedule(s string) (*Schedule, error) {
+	raw := s
+
+	switch s {
+	case "@hourly":
+		s = "0 * * * *"
+	case "@daily", "@midnight":
+		s = "0 0 * * *"
+	case "@weekly":
+		s = "0 0 * * 0"
+	case "@monthly":
+		s = "0 0 1 * *"
+	}
+
+	if every, ok := strings.CutPrefix(s, "@every "); ok {
+		s = every
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < time.Minute {
+			return nil, errors.New("schedule: interval less than 1m: " + s)
+		}
+		return &Schedule{raw: raw, every: d}, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, errors.New("schedule: wrong format: " + s)
+	}
+
+	sched := Schedule{raw: raw}
+
+	for i, limits := range [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}} {
+		bits, err := parseCronField(fields[i], limits[0], limits[1])
+		if err != nil {
+			return nil, fmt.Errorf("schedule: %w: %s", err, s)
+		}
+		sched.fields[i] = bits
+	}
+
+	// Sunday can be 0 or 7
+	if sched.fields[4]&(1<<7) != 0 {
+		sched.fields[4] |= 1
+	}
+
+	return &sched, nil
+}
+
+func parseCronField(field string, min, max int) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		item, step, ok := strings.Cut(item, "/")
+
+		n := 1
+		if ok {
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, errors.New("wrong step")
+			}
+		}
+
+		from, to := min, max
+
+		if item != "*" {
+			s1, s2, ok := strings.Cut(item, "-")
+			if from, err = strconv.Atoi(s1); err != nil {
+				return 0, errors.New("wrong value")
+			}
+			if ok {
+				if to, err = strconv.Atoi(s2); err != nil {
+					return 0, errors.New("wrong value")
+				}
+			} else if step == "" {
+				to = from
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return 0, errors.New("value out of range")
+		}
+
+		for i := from; i <= to; i += n {
+			bits |= 1 << i
+		}
+	}
+
+	return
+}
+
+// Next returns next run time after t
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every != 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// five years is enough for any valid expression (Feb 29)
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case !s.match(3, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.match(1, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.match(0, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) match(field, value int) bool {
+	return s.fields[field]&(1<<value) != 0
+}
+
+// matchDay - if both day of month and day of week are restricted, any of them should match (as in cron)
+func (s *Schedule) matchDay(t time.Time) bool {
+	const anyDOM = 1<<32 - 2 // 1-31
+	const anyDOW = 1<<8 - 1  // 0-7
+
+	dom := s.match(2, t.Day())
+	dow := s.match(4, int(t.Weekday()))
+
+	switch {
+	case s.fields[2] == anyDOM:
+		return dow
+	case s.fields[4] == anyDOW:
+		return dom
+	}
+	return dom || dow
+}
+
+func (s *Schedule) String() string {
+	return s.raw
+}
+
+func (s *Schedule) UnmarshalYAML(node *yaml.Node) error {
+	sched, err := ParseSchedule(node.Value)
+	if err != nil {
+		return err
+	}
+	*s = *sched
+	return nil
+}
+
+type Scheduler struct {
+	stop chan struct{}
+}
+
+// StartScheduler runs syncs with schedule by own timers and other syncs every repeat interval.
+// Syncs without schedule runs immediately. Each sync runs together with its dependencies.
+func StartScheduler(syncs []*Sync, repeat time.Duration, run func([]*Sync)) *Scheduler {
+	s := &Scheduler{stop: make(chan struct{})}
+
+	var names []string
+	for _, sync := range syncs {
+		if sync.Schedule == nil {
+			names = append(names, sync.Name)
+		}
+	}
+
+	if names != nil {
+		selected := SelectSyncs(syncs, names...)
+		run(selected)
+
+		if repeat > 0 {
+			go func() {
+				ticker := time.NewTicker(repeat)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ticker.C:
+						run(selected)
+					case <-s.stop:
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	for _, sync := range syncs {
+		if sync.Schedule == nil {
+			continue
+		}
+
+		selected := SelectSyncs(syncs, sync.Name)
+
+		// interval schedule runs immediately as repeat option
+		if sync.Schedule.every != 0 {
+			run(selected)
+		}
+
+		go func() {
+			for {
+				next := sync.Schedule.Next(time.Now())
+				if next.IsZero() {
+					log.Printf("%s: schedule has no next time\n", sync.Name)
+					return
+				}
+
+				if sync.Jitter > 0 {
+					next = next.Add(rand.N(sync.Jitter))
+				}
+
+				timer := time.NewTimer(time.Until(next))
+				select {
+				case <-timer.C:
+					run(selected)
+				case <-s.stop:
+					timer.Stop()
+					return
+				}
+			}
+		}()
+	}
+
+	return s
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
@@ -0,0 +1,358 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Sync struct {
+	Name      string            `yaml:"-"`
+	From      any               `yaml:"from"`
+	To        Target            `yaml:"to"`
+	Expr      map[string]string `yaml:"expr"`
+	DependsOn stringList        `yaml:"depends_on"`
+	Schedule  *Schedule         `yaml:"schedule"`
+	Jitter    time.Duration     `yaml:"jitter"`
+	Timeout   time.Duration     `yaml:"timeout"`
+}
+
+// ParseSyncs returns syncs in config file order, with dependencies moved before dependent syncs
+func ParseSyncs(data []byte) ([]*Sync, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	// empty config
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	node := root.Content[0]
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("config: wrong format")
+	}
+
+	var syncs []*Sync
+
+	for i := 0; i < len(node.Content); i += 2 {
+		sync := &Sync{Name: node.Content[i].Value}
+		if err := node.Content[i+1].Decode(sync); err != nil {
+			return nil, fmt.Errorf("%s: %w", sync.Name, err)
+		}
+
+		if sync.From == nil || sync.To.Config == "" {
+			continue
+		}
+
+		syncs = append(syncs, sync)
+	}
+
+	return sortSyncs(syncs)
+}
+
+func sortSyncs(syncs []*Sync) ([]*Sync, error) {
+	names := make(map[string]bool, len(syncs))
+	for _, sync := range syncs {
+		names[sync.Name] = true
+	}
+
+	for _, sync := range syncs {
+		for _, dep := range sync.DependsOn {
+			if !names[dep] {
+				return nil, fmt.Errorf("%s: unknown dependency: %s", sync.Name, dep)
+			}
+		}
+	}
+
+	sorted := make([]*Sync, 0, len(syncs))
+	done := make(map[string]bool, len(syncs))
+
+	// every time take the first sync in file order with all dependencies done
+	for len(sorted) < len(syncs) {
+		i := slices.IndexFunc(syncs, func(sync *Sync) bool {
+			return !done[sync.Name] && !slices.ContainsFunc(sync.DependsOn, func(dep string) bool {
+				return !done[dep]
+			})
+		})
+		if i < 0 {
+			return nil, errors.New("config: dependency cycle")
+		}
+
+		sorted = append(sorted, syncs[i])
+		done[syncs[i].Name] = true
+	}
+
+	return sorted, nil
+}
+
+// SelectSyncs returns syncs with selected names and all their dependencies
+func SelectSyncs(syncs []*Sync, names ...string) []*Sync {
+	selected := make(map[string]bool)
+
+	// syncs sorted by dependencies, so go from end to start
+	for i := len(syncs) - 1; i >= 0; i-- {
+		sync := syncs[i]
+		if selected[sync.Name] || slices.Contains(names, sync.Name) {
+			selected[sync.Name] = true
+			for _, dep := range sync.DependsOn {
+				selected[dep] = true
+			}
+		}
+	}
+
+	return slices.DeleteFunc(slices.Clone(syncs), func(sync *Sync) bool {
+		return !selected[sync.Name]
+	})
+}
+
+// HasSchedule returns true if any sync has own schedule
+func HasSchedule(syncs []*Sync) bool {
+	return slices.ContainsFunc(syncs, func(sync *Sync) bool {
+		return sync.Schedule != nil
+	})
+}
+
+type Result struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status"` // ok, error, skipped
+	Error   string         `json:"error,omitempty"`
+	Time    time.Time      `json:"time"`
+	Weights []*core.Weight `json:"weights,omitempty"` // data for stdout destination
+
+	Failed []*core.FailedWeight `json:"failed,omitempty"` // weighings that were not saved
+}
+
+const (
+	StatusOK      = "ok"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+var (
+	results   = map[string]*Result{}
+	resultsMu sync.Mutex
+)
+
+// RunSyncs runs independent syncs in parallel and skips syncs with failed dependencies.
+// With capture option, data for stdout destination is returned in results.
+func RunSyncs(ctx context.Context, syncs []*Sync, capture bool) []*Result {
+	items := make([]*Result, len(syncs))
+	done := make(map[string]chan struct{}, len(syncs))
+
+	for i, sync := range syncs {
+		items[i] = &Result{Name: sync.Name, Time: time.Now()}
+		done[sync.Name] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+
+	for i, s := range syncs {
+		wg.Add(1)
+		go func(sync *Sync, res *Result) {
+			defer wg.Done()
+			defer close(done[sync.Name])
+
+			// syncs with same account or same file run in config order
+			for _, prev := range syncs[:i] {
+				if sharesResources(prev, sync) {
+					<-done[prev.Name]
+				}
+			}
+
+			// wait dependencies from the same run
+			for _, dep := range sync.DependsOn {
+				if ch, ok := done[dep]; ok {
+					<-ch
+				}
+			}
+
+			for _, dep := range sync.DependsOn {
+				var status string
+				if i := slices.IndexFunc(syncs, func(s *Sync) bool { return s.Name == dep }); i >= 0 {
+					status = items[i].Status
+				} else {
+					// dependency is not in this run (scheduled or selected sync), so check its last run
+					status = lastStatus(dep)
+					if status == "" {
+						log.Printf("%s: dependency %s has not run yet\n", sync.Name, dep)
+						continue
+					}
+				}
+				if status != StatusOK {
+					log.Printf("%s: skipped: dependency %s failed\n", sync.Name, dep)
+					res.Status = StatusSkipped
+					res.Error = "dependency " + dep + " failed"
+					return
+				}
+			}
+
+			if err := runWorker(ctx, sync, res, capture); err != nil {
+				log.Printf("%s: %v\n", sync.Name, err)
+				res.Status = StatusError
+				res.Error = err.Error()
+
+				var weightsErr *core.WeightsError
+				if errors.As(err, &weightsErr) {
+					res.Failed = weightsErr.Failed
+					for _, failed := range res.Failed {
+						log.Printf("%s: %s: %s\n", sync.Name, failed.Date.Format(time.DateTime), failed.Error)
+					}
+				}
+				return
+			}
+
+			log.Printf("%s: OK\n", sync.Name)
+			res.Status = StatusOK
+		}(s, items[i])
+	}
+
+	wg.Wait()
+
+	resultsMu.Lock()
+	for _, res := range items {
+		results[res.Name] = res
+	}
+	resultsMu.Unlock()
+
+	return items
+}
+
+func sharesResources(a, b *Sync) bool {
+	keys := b.Resources()
+	return slices.ContainsFunc(a.Resources(), func(key string) bool {
+		return slices.Contains(keys, key)
+	})
+}
+
+// lastStatus returns status of the last sync run, empty if sync has not run yet
+func lastStatus(name string) string {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	if res, ok := results[name]; ok {
+		return res.Status
+	}
+	return ""
+}
+
+// LastResults returns last results for selected syncs
+func LastResults(syncs []*Sync) []*Result {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	var items []*Result
+	for _, sync := range syncs {
+		if res, ok := results[sync.Name]; ok {
+			items = append(items, &Result{Name: res.Name, Status: res.Status, Error: res.Error, Time: res.Time, Failed: res.Failed})
+		}
+	}
+	return items
+}
+
+func (s *Sync) Run(ctx context.Context, res *Result, capture bool) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	weights, err := GetWeights(ctx, s.From)
+	if err != nil {
+		return fmt.Errorf("load data error: %w", err)
+	}
+
+	if s.Expr != nil {
+		if err = Expr(s.Expr, weights); err != nil {
+			return fmt.Errorf("calc expr error: %w", err)
+		}
+	}
+
+	if capture {
+		if fields := strings.Fields(s.To.Config); len(fields) == 2 && fields[1] == "stdout" {
+			res.Weights = prepareFile(weights)
+			return nil
+		}
+	}
+
+	if err = SetWeights(ctx, &s.To, weights); err != nil {
+		return fmt.Errorf("write data error: %w", err)
+	}
+
+	return nil
+}
+
+// Accounts returns keys of all accounts used in sync
+func (s *Sync) Accounts() []string {
+	var keys []string
+	for _, config := range []any{s.From, s.To.Config} {
+		config, ok := config.(string)
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(config); len(fields) > 1 && isAccount(fields[0]) {
+			keys = append(keys, accountKey(fields))
+			// auto mode also uses Xiaomi Home account
+			if fields[0] == AccXiaomi && len(fields) > 3 && fields[3] == "auto" {
+				keys = append(keys, accountKey(xiaomiHomeFields(fields)))
+			}
+		}
+	}
+	return keys
+}
+
+// Resources returns keys of all accounts and local files used in sync
+func (s *Sync) Resources() []string {
+	keys := s.Accounts()
+	for _, config := range []any{s.From, s.To.Config} {
+		config, ok := config.(string)
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(config)
+		if len(fields) < 2 || (fields[0] != "csv" && fields[0] != "json" && fields[0] != "fit") {
+			continue
+		}
+		if fields[1] != "stdout" && !strings.Contains(fields[1], "://") {
+			keys = append(keys, "file:"+fields[1])
+		}
+	}
+	return keys
+}
+
+// Target - destination config, string or map with account options
+type Target struct {
+	Config      string                  `yaml:"account"`
+	Users       map[string]string       `yaml:"users"`        // source user name to destination user name
+	CreateUsers map[string]*core.Member `yaml:"create_users"` // destination user name to new member params
+}
+
+func (t *Target) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		t.Config = node.Value
+		return nil
+	}
+	type plain Target
+	return node.Decode((*plain)(t))
+}
+
+// stringList support single string and list of strings in config
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = []string{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(l))
+}
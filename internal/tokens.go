@@ -1,48 +1,352 @@
 package internal
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-var tokens = map[string]string{}
+const (
+	tokensName    = "scaleconnect.json"
+	tokensVersion = 2
+)
+
+type Token struct {
+	Token   string            `json:"token"`
+	Expires time.Time         `json:"expires,omitzero"` // for tokens with known lifetime
+	Updated time.Time         `json:"updated,omitzero"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+type tokensFile struct {
+	Version  int               `json:"version"`
+	Accounts map[string]*Token `json:"accounts,omitempty"`
+	Data     []byte            `json:"data,omitempty"` // encrypted accounts
+}
+
+var (
+	tokens    map[string]*Token
+	tokensErr error
+	tokensDir string
+	tokensMu  sync.Mutex
+)
+
+// SetDataDir changes folder for tokens file, default is current working directory
+func SetDataDir(dir string) {
+	// relative to the start folder, because app may change CWD
+	if abs, err := filepath.Abs(dir); err == nil && dir != "" {
+		dir = abs
+	}
+	tokensDir = dir
+}
 
 func LoadToken(key string) string {
+	name, _, _ := strings.Cut(key, ":")
 	key = replaceKey(key)
 
-	if len(tokens) == 0 {
-		f, err := os.Open("scaleconnect.json")
-		if err != nil {
-			return ""
-		}
-		defer f.Close()
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	loadTokens()
+
+	if tokens[key] == nil {
+		migrateToken(name, key)
+	}
+
+	if token := tokens[key]; token != nil && (token.Expires.IsZero() || time.Now().Before(token.Expires)) {
+		return token.Token
+	}
+	return ""
+}
+
+// migrateToken moves token from old key without username (garmin, zepp/xiaomi, xiaomi, etc.),
+// so users don't need to login again after update. Old versions had one token per account type,
+// so it is moved to the first account that asks it.
+func migrateToken(name, key string) {
+	token := tokens[name]
+	if token == nil || tokensErr != nil {
+		return
+	}
+
+	tokens[key] = token
+	delete(tokens, name)
+
+	log.Printf("tokens: token %s moved to %s\n", name, key)
+
+	if err := saveTokens(); err != nil {
+		log.Printf("tokens: %v\n", err)
+	}
+}
+
+func SaveToken(key string, value string) error {
+	key = replaceKey(key)
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	loadTokens()
+
+	// don't overwrite file that we can't read
+	if tokensErr != nil {
+		return tokensErr
+	}
 
-		_ = json.NewDecoder(f).Decode(&tokens)
+	token := tokens[key]
+	if token == nil {
+		token = &Token{}
+		tokens[key] = token
 	}
+	token.Token = value
+	token.Updated = time.Now()
 
-	return tokens[key]
+	return saveTokens()
 }
 
-func SaveToken(key string, value string) {
+// LoadMeta returns additional account value, saved near the token
+func LoadMeta(key, name string) string {
 	key = replaceKey(key)
 
-	tokens[key] = value
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
 
-	f, err := os.Create("scaleconnect.json")
+	loadTokens()
+
+	if token := tokens[key]; token != nil {
+		return token.Meta[name]
+	}
+	return ""
+}
+
+func SaveMeta(key, name, value string) error {
+	key = replaceKey(key)
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+
+	loadTokens()
+
+	if tokensErr != nil {
+		return tokensErr
+	}
+
+	token := tokens[key]
+	if token == nil {
+		token = &Token{}
+		tokens[key] = token
+	}
+	if token.Meta == nil {
+		token.Meta = map[string]string{}
+	}
+	token.Meta[name] = value
+
+	return saveTokens()
+}
+
+func loadTokens() {
+	if tokens != nil {
+		return
+	}
+
+	tokens = map[string]*Token{}
+
+	data, err := os.ReadFile(filepath.Join(tokensDir, tokensName))
 	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			tokensErr = fmt.Errorf("tokens: %w", err)
+		}
 		return
 	}
-	defer f.Close()
 
-	_ = json.NewEncoder(f).Encode(&tokens)
+	if tokensErr = unmarshalTokens(data); tokensErr != nil {
+		tokensErr = fmt.Errorf("tokens: %w", tokensErr)
+		return
+	}
+
+}
+
+func unmarshalTokens(data []byte) error {
+	var file tokensFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	switch file.Version {
+	case 0:
+		// version 1 - plain map with tokens
+		var old map[string]string
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+		for key, value := range old {
+			tokens[key] = &Token{Token: value}
+		}
+		return nil
+
+	case tokensVersion:
+		if file.Data == nil {
+			for key, token := range file.Accounts {
+				tokens[key] = token
+			}
+			return nil
+		}
+
+		key, err := tokensKey()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return errors.New("file is encrypted, but key is not set")
+		}
+
+		data, err = decrypt(key, file.Data)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &tokens)
+
+	default:
+		return fmt.Errorf("unsupported version: %d", file.Version)
+	}
+}
+
+func saveTokens() error {
+	file := tokensFile{Version: tokensVersion}
+
+	key, err := tokensKey()
+	if err != nil {
+		return err
+	}
+
+	if key != nil {
+		data, err := json.Marshal(tokens)
+		if err != nil {
+			return err
+		}
+		if file.Data, err = encrypt(key, data); err != nil {
+			return err
+		}
+	} else {
+		file.Accounts = tokens
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(filepath.Join(tokensDir, tokensName), data)
+}
+
+// writeAtomic writes data to temp file and replaces target file, so crash never leaves half of the file
+func writeAtomic(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	// temp file is created with 0600 permissions
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+
+	return err
+}
+
+// tokensKey returns encryption key from SCALECONNECT_KEY or SCALECONNECT_KEY_FILE env
+func tokensKey() ([]byte, error) {
+	secret := os.Getenv("SCALECONNECT_KEY")
+	if secret == "" {
+		if name := os.Getenv("SCALECONNECT_KEY_FILE"); name != "" {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+	}
+	if secret == "" {
+		return nil, nil
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	return key[:], nil
+}
+
+func encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("wrong encrypted data")
+	}
+
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong encryption key")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
 func replaceKey(key string) string {
-	key, value, _ := strings.Cut(key, ":")
-	switch key {
+	name, value, _ := strings.Cut(key, ":")
+	switch name {
 	case AccMiFitness, AccXiaomiHome:
 		return AccXiaomi + ":" + value
+	case AccZeppHuami:
+		return AccZeppEmail + ":" + value
 	}
 	return key
 }
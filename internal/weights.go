@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,13 +16,14 @@ import (
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
 	"github.com/AlexxIT/SmartScaleConnect/pkg/csv"
 	"github.com/AlexxIT/SmartScaleConnect/pkg/fitbit"
+	"github.com/AlexxIT/SmartScaleConnect/pkg/garmin"
 	"github.com/AlexxIT/SmartScaleConnect/pkg/xiaomi"
 )
 
-func GetWeights(from any) ([]*core.Weight, error) {
+func GetWeights(ctx context.Context, from any) ([]*core.Weight, error) {
 	switch from.(type) {
 	case string:
-		return getWeights(from.(string))
+		return getWeights(ctx, from.(string))
 
 	case map[string]any:
 		data, err := json.Marshal(from)
@@ -54,19 +56,19 @@ func GetWeights(from any) ([]*core.Weight, error) {
 	return nil, fmt.Errorf("wrong from format: %v", from)
 }
 
-func getWeights(config string) ([]*core.Weight, error) {
+func getWeights(ctx context.Context, config string) ([]*core.Weight, error) {
 	switch fields := strings.Fields(config); fields[0] {
 	case "csv":
-		rd, err := openFile(fields[1])
+		rd, err := openFile(ctx, fields[1])
 		if err != nil {
 			return nil, err
 		}
 		defer rd.Close()
 
-		return csv.Read(rd)
+		return csv.Read(rd, csvLocal(fields))
 
 	case "json":
-		rd, err := openFile(fields[1])
+		rd, err := openFile(ctx, fields[1])
 		if err != nil {
 			return nil, err
 		}
@@ -78,60 +80,89 @@ func getWeights(config string) ([]*core.Weight, error) {
 		}
 		return weights, nil
 
+	case "fit":
+		return readFit(fields[1])
+
 	case "fitbit":
 		return fitbit.Read(fields[1])
 
-	case AccGarmin, AccTanita:
-		acc, err := GetAccount(fields)
-		if err != nil {
-			return nil, err
-		}
-		return acc.GetAllWeights()
+	case "garmin-export":
+		return garmin.ReadExport(fields[1])
 
-	case AccMiFitness, AccPicooc, AccXiaomi, AccZeppXiaomi:
-		acc, err := GetAccount(fields)
+	case "garmin-csv":
+		rd, err := openFile(ctx, fields[1])
 		if err != nil {
 			return nil, err
 		}
+		defer rd.Close()
 
-		if len(fields) < 4 {
-			return acc.GetAllWeights()
-		}
+		return garmin.ReadCSV(rd)
 
-		return acc.(core.AccountWithFilter).GetFilterWeights(fields[3])
+	case AccGarmin, AccTanita:
+		var weights []*core.Weight
+		err := withAccount(ctx, fields, func(acc core.Account) (err error) {
+			weights, err = acc.GetAllWeights(ctx)
+			return
+		})
+		return weights, err
 
-	case AccXiaomiHome:
-		acc, err := GetAccount(fields)
-		if err != nil {
-			return nil, err
+	case AccMiFitness, AccPicooc, AccXiaomi, AccZeppXiaomi, AccZeppEmail, AccZeppHuami:
+		if fields[0] == AccXiaomi && len(fields) > 3 && fields[3] == "auto" {
+			return getXiaomiAuto(ctx, fields)
 		}
 
-		return acc.(*xiaomi.Client).GetModelWeights(fields[3], fields[4])
+		var weights []*core.Weight
+		err := withAccount(ctx, fields, func(acc core.Account) (err error) {
+			if len(fields) < 4 {
+				weights, err = acc.GetAllWeights(ctx)
+			} else if client, ok := acc.(*xiaomi.Client); ok && len(fields) > 4 {
+				// mifitness {username} {password} {scales model} {user}
+				weights, err = client.GetScaleWeights(ctx, fields[3], strings.Join(fields[4:], " "))
+			} else {
+				weights, err = acc.(core.AccountWithFilter).GetFilterWeights(ctx, fields[3])
+			}
+			return
+		})
+		return weights, err
+
+	case AccXiaomiHome:
+		var weights []*core.Weight
+		err := withAccount(ctx, fields, func(acc core.Account) (err error) {
+			// xiaomihome {username} {password} {region} {scales model} {user}
+			weights, err = acc.(*xiaomi.Client).GetModelWeights(ctx, fields[3], fields[4], strings.Join(fields[5:], " "))
+			return
+		})
+		return weights, err
 
 	default:
 		return nil, errors.New("unsupported type: " + fields[0])
 	}
 }
 
-func SetWeights(config string, src []*core.Weight) error {
+func SetWeights(ctx context.Context, to *Target, src []*core.Weight) error {
+	config := to.Config
+
 	switch fields := strings.Fields(config); fields[0] {
 	case "csv", "json":
-		return writeFile(config, src)
+		return writeFile(ctx, config, src)
+
+	case "fit":
+		return writeFit(config, src)
 
-	case AccGarmin, AccZeppXiaomi:
-		return appendAccount(config, src)
+	case AccGarmin, AccMiFitness, AccXiaomi, AccZeppXiaomi, AccZeppEmail, AccZeppHuami:
+		return appendAccount(ctx, to, src)
 
 	case "json/latest":
-		return postLatest(config, src)
+		return postLatest(ctx, config, src)
 
 	default:
 		return errors.New("unsupported type: " + fields[0])
 	}
 }
 
-func openFile(path string) (io.ReadCloser, error) {
+func openFile(ctx context.Context, path string) (io.ReadCloser, error) {
 	if strings.Contains(path, "://") {
-		res, err := http.Get(path)
+		res, err := core.Get(ctx, http.DefaultClient, path)
 		if err != nil {
 			return nil, err
 		}
@@ -145,22 +176,23 @@ func openFile(path string) (io.ReadCloser, error) {
 	}
 }
 
-func writeFile(config string, src []*core.Weight) error {
+func writeFile(ctx context.Context, config string, src []*core.Weight) error {
 	fields := strings.Fields(config)
 	format := fields[0]
 	filename := fields[1]
+	local := csvLocal(fields)
 
 	if strings.Contains(filename, "://") {
-		return postFile(format, filename, src)
+		return postFile(ctx, format, filename, src, local)
 	}
 
 	if filename == "stdout" {
-		return writeToStdout(format, src)
+		return writeToStdout(format, src, local)
 	}
 
 	// important read file before os.Create
 	// empty dst file is OK
-	dst, _ := GetWeights(config)
+	dst, _ := GetWeights(ctx, config)
 	dst = appendFile(dst, src)
 
 	f, err := os.Create(filename)
@@ -170,7 +202,7 @@ func writeFile(config string, src []*core.Weight) error {
 	defer f.Close()
 
 	if format == "csv" {
-		return csv.Write(f, dst)
+		return csv.Write(f, dst, local)
 	} else {
 		return json.NewEncoder(f).Encode(dst)
 	}
@@ -206,20 +238,39 @@ func appendFile(dst, src []*core.Weight) []*core.Weight {
 	return dst
 }
 
-func appendAccount(config string, src []*core.Weight) error {
-	dst, err := GetWeights(config)
+func appendAccount(ctx context.Context, to *Target, src []*core.Weight) error {
+	fields := strings.Fields(to.Config)
+
+	// options are passed to each call, because the account client is shared between syncs
+	opts := core.Options{Members: to.CreateUsers}
+
+	if fields[0] == AccMiFitness || fields[0] == AccXiaomi {
+		// mifitness {username} {password} {region}, same region for read and write
+		if len(fields) > 3 {
+			if len(fields) > 4 || xiaomi.MiFitnessURL(fields[3]) == "" {
+				return errors.New("xiaomi: destination supports only region option: " + strings.Join(fields[3:], " "))
+			}
+			opts.Region = fields[3]
+		}
+	}
+
+	dst, err := GetWeights(ctx, to.Config)
 	if err != nil {
 		return err
 	}
 
-	acc, err := GetAccount(strings.Fields(config))
+	acc, err := GetAccount(ctx, fields)
 	if err != nil {
 		return err
 	}
 
+	if to.Users != nil {
+		src = renameUsers(src, to.Users)
+	}
+
 	client := acc.(core.AccountWithAddWeights)
 
-	var add []*core.Weight
+	var add, del []*core.Weight
 
 	for _, s := range src {
 		i := slices.IndexFunc(dst, func(d *core.Weight) bool {
@@ -230,14 +281,10 @@ func appendAccount(config string, src []*core.Weight) error {
 			d := dst[i]
 			if s.Weight == 0 {
 				// remove
-				if err = client.DeleteWeight(d); err != nil {
-					return err
-				}
+				del = append(del, d)
 			} else if !client.Equal(s, d) {
 				// replace
-				if err = client.DeleteWeight(d); err != nil {
-					return err
-				}
+				del = append(del, d)
 				add = append(add, s)
 			} else {
 				// skip
@@ -251,11 +298,40 @@ func appendAccount(config string, src []*core.Weight) error {
 		}
 	}
 
+	for _, d := range del {
+		if err = withAccount(ctx, fields, func(acc core.Account) error {
+			return acc.(core.AccountWithAddWeights).DeleteWeight(ctx, d, opts)
+		}); err != nil {
+			return err
+		}
+	}
+
 	if len(add) == 0 {
 		return nil
 	}
 
-	return client.AddWeights(add)
+	return withAccount(ctx, fields, func(acc core.Account) error {
+		return acc.(core.AccountWithAddWeights).AddWeights(ctx, add, opts)
+	})
+}
+
+// renameUsers returns copy of weighings with destination user names
+func renameUsers(src []*core.Weight, users map[string]string) []*core.Weight {
+	dst := make([]*core.Weight, len(src))
+	for i, s := range src {
+		if name, ok := users[s.User]; ok {
+			w := *s
+			w.User = name
+			s = &w
+		}
+		dst[i] = s
+	}
+	return dst
+}
+
+// csvLocal - option for CSV dates in time zone of measurement place: csv {path} local
+func csvLocal(fields []string) bool {
+	return fields[0] == "csv" && slices.Contains(fields[2:], "local")
 }
 
 func prepareFile(src []*core.Weight) []*core.Weight {
@@ -275,26 +351,32 @@ func prepareFile(src []*core.Weight) []*core.Weight {
 	return dst
 }
 
-func postFile(format, url string, src []*core.Weight) (err error) {
+func postFile(ctx context.Context, format, url string, src []*core.Weight, local bool) (err error) {
 	body := bytes.NewBuffer(nil)
 	dst := prepareFile(src)
 
+	var res *http.Response
+
 	if format == "csv" {
-		if err = csv.Write(body, dst); err != nil {
+		if err = csv.Write(body, dst, local); err != nil {
 			return err
 		}
-		_, err = http.Post(url, "text/csv", body)
+		res, err = core.Post(ctx, http.DefaultClient, url, "text/csv", body)
 	} else {
 		if err = json.NewEncoder(body).Encode(dst); err != nil {
 			return err
 		}
-		_, err = http.Post(url, "application/json", body)
+		res, err = core.Post(ctx, http.DefaultClient, url, "application/json", body)
+	}
+
+	if err != nil {
+		return err
 	}
 
-	return
+	return res.Body.Close()
 }
 
-func postLatest(config string, src []*core.Weight) error {
+func postLatest(ctx context.Context, config string, src []*core.Weight) error {
 	slices.SortFunc(src, func(a, b *core.Weight) int {
 		return b.Date.Compare(a.Date) // latest first
 	})
@@ -311,7 +393,7 @@ func postLatest(config string, src []*core.Weight) error {
 
 		fields := strings.Fields(config)
 
-		res, err := http.Post(fields[1], "application/json", bytes.NewBuffer(data))
+		res, err := core.Post(ctx, http.DefaultClient, fields[1], "application/json", bytes.NewBuffer(data))
 		if err != nil {
 			return err
 		}
@@ -323,12 +405,24 @@ func postLatest(config string, src []*core.Weight) error {
 	return nil
 }
 
-func writeToStdout(format string, src []*core.Weight) error {
+func writeToStdout(format string, src []*core.Weight, local bool) error {
 	dst := prepareFile(src)
+	buf := bytes.NewBuffer(nil)
 
 	if format == "csv" {
-		return csv.Write(os.Stdout, dst)
+		if err := csv.Write(buf, dst, local); err != nil {
+			return err
+		}
 	} else {
-		return json.NewEncoder(os.Stdout).Encode(dst)
+		if err := json.NewEncoder(buf).Encode(dst); err != nil {
+			return err
+		}
 	}
+
+	// same lock as protocol responses, so lines don't mix
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
 }
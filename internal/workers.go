@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+var workers = make(chan struct{}, 4)
+
+// SetWorkers changes max number of syncs running in parallel
+func SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	workers = make(chan struct{}, n)
+}
+
+var (
+	locks   = map[string]*sync.Mutex{}
+	locksMu sync.Mutex
+)
+
+// runWorker locks all sync resources and waits free worker, so syncs with same account
+// or same file never run at the same time. Locks go first, so syncs waiting for busy account
+// don't hold workers needed by other syncs.
+func runWorker(ctx context.Context, sync *Sync, res *Result, capture bool) error {
+	// same order for all syncs to prevent deadlocks
+	keys := slices.Compact(slices.Sorted(slices.Values(sync.Resources())))
+
+	for _, key := range keys {
+		mu := getLock(key)
+		mu.Lock()
+		defer mu.Unlock()
+	}
+
+	w := &worker{ch: workers}
+	if err := w.take(ctx); err != nil {
+		return err
+	}
+	defer w.free()
+
+	res.Time = time.Now()
+
+	return runWithRetry(context.WithValue(ctx, workerKey{}, w), sync, res, capture)
+}
+
+// worker - slot of running sync, it is free while sync waits for retry or for user code
+type worker struct {
+	ch   chan struct{}
+	held bool
+	mu   sync.Mutex
+}
+
+type workerKey struct{}
+
+func getWorker(ctx context.Context) *worker {
+	w, _ := ctx.Value(workerKey{}).(*worker)
+	return w
+}
+
+func (w *worker) take(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.held {
+		return nil
+	}
+
+	select {
+	case w.ch <- struct{}{}:
+		w.held = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *worker) free() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	if w.held {
+		<-w.ch
+		w.held = false
+	}
+	w.mu.Unlock()
+}
+
+func getLock(key string) *sync.Mutex {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+
+	mu := locks[key]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		locks[key] = mu
+	}
+	return mu
+}
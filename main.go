@@ -2,17 +2,22 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/internal"
-	"gopkg.in/yaml.v3"
 )
 
 const Version = "0.4.0"
@@ -21,7 +26,13 @@ const usage = `Usage of scaleconnect:
 
   -c, --config       Path to config file
   -i, --interactive  Keep STDIN open
-  -r, --repeat       Run config every N time (format: 2h45m)
+  -r, --repeat       Run syncs without schedule every N time (format: 2h45m)
+  -w, --workers      Max syncs running in parallel (default: 4)
+  -d, --data-dir     Path to folder for tokens file (default: current working directory)
+
+Commands:
+
+  discover xiaomi {username} {password}  List Xiaomi scales from all regions
 `
 
 func main() {
@@ -29,6 +40,8 @@ func main() {
 		config      string
 		repeat      string
 		interactive bool
+		workers     int
+		dataDir     string
 	)
 
 	flag.Usage = func() { fmt.Print(usage) }
@@ -38,140 +51,335 @@ func main() {
 	flag.StringVar(&repeat, "r", "", "")
 	flag.BoolVar(&interactive, "interactive", false, "")
 	flag.BoolVar(&interactive, "i", false, "")
+	flag.IntVar(&workers, "workers", 4, "")
+	flag.IntVar(&workers, "w", 4, "")
+	flag.StringVar(&dataDir, "data-dir", "", "")
+	flag.StringVar(&dataDir, "d", "", "")
 	flag.Parse()
 
 	log.Printf("scaleconnect version %s\n", Version)
 
-	data, err := readConfig(config)
+	internal.SetWorkers(workers)
+	internal.SetDataDir(dataDir)
+	internal.SetInteractive(interactive)
+
+	// cancel all running syncs on exit
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if args := flag.Args(); len(args) > 0 && args[0] == "discover" {
+		discover(ctx, args[1:])
+		return
+	}
+
+	var syncs []*internal.Sync
+
+	data, path, err := readConfig(config)
+	if err == nil {
+		if syncs, err = internal.ParseSyncs(data); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// run config once
-	if repeat == "" && !interactive {
+	if repeat == "" && !interactive && !internal.HasSchedule(syncs) {
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		if err = process(data); err != nil {
+		internal.RunSyncs(ctx, syncs, false)
+
+		return
+	}
+
+	var sleep time.Duration
+	if repeat != "" {
+		if sleep, err = time.ParseDuration(repeat); err != nil {
 			log.Fatal(err)
 		}
+	}
+
+	// syncs with same account are locked inside RunSyncs
+	runSyncs := func(syncs []*internal.Sync) {
+		go internal.RunSyncs(ctx, syncs, false)
+	}
+
+	// syncs may be changed by reload from main goroutine
+	var mu sync.Mutex
+
+	currentSyncs := func() []*internal.Sync {
+		mu.Lock()
+		defer mu.Unlock()
+		return syncs
+	}
 
-		os.Exit(0)
+	var scheduler *internal.Scheduler
+	if syncs != nil {
+		scheduler = internal.StartScheduler(syncs, sleep, runSyncs)
 	}
 
-	configs := make(chan []byte, 10)
+	if interactive {
+		go readStdin(ctx, currentSyncs)
+	}
 
-	if data != nil {
-		configs <- data
+	reload := make(chan struct{}, 1)
+	if path != "" {
+		go watchConfig(path, reload)
+	}
 
-		if repeat != "" {
-			var sleep time.Duration
-			if sleep, err = time.ParseDuration(repeat); err != nil {
-				log.Fatal(err)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				fmt.Printf("exit with signal: %s\n", sig)
+				return
 			}
+		case <-reload:
+		}
 
-			go func() {
-				for range time.NewTicker(sleep).C {
-					configs <- data
-				}
-			}()
+		if path == "" {
+			log.Println("config: nothing to reload")
+			continue
+		}
+
+		newSyncs, changed, err := reloadConfig(path, syncs)
+		if err != nil {
+			log.Printf("config: reload error: %v\n", err)
+			continue
+		}
+
+		if !changed {
+			log.Println("config: reload, no changes")
+			continue
+		}
+
+		if scheduler != nil {
+			scheduler.Stop()
 		}
+
+		mu.Lock()
+		syncs = newSyncs
+		mu.Unlock()
+
+		internal.KeepAccounts(newSyncs)
+
+		scheduler = internal.StartScheduler(syncs, sleep, runSyncs)
 	}
+}
 
-	if interactive {
-		go func() {
-			// read stdin and process it forever
-			reader := bufio.NewReader(os.Stdin)
-			for {
-				data, err := reader.ReadBytes('\n')
-				if err != nil {
-					break
-				}
-				configs <- data
-			}
-		}()
+// readStdin reads configs or protocol requests from stdin and process them forever
+func readStdin(ctx context.Context, currentSyncs func() []*internal.Sync) {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+
+		if req, err := internal.ParseRequest(data); err != nil {
+			internal.WriteResponse(&internal.Response{ID: req.ID, Error: err.Error()})
+			continue
+		} else if req != nil {
+			handleRequest(ctx, req, currentSyncs)
+			continue
+		}
+
+		// single word line is answer for waiting prompt
+		if line := strings.TrimSpace(string(data)); !strings.ContainsAny(line, " :{") && internal.AnswerPrompt("", line) {
+			continue
+		}
+
+		// all syncs from stdin runs immediately
+		syncs, err := internal.ParseSyncs(data)
+		if err != nil {
+			log.Printf("stdin: %v\n", err)
+			continue
+		}
+
+		go internal.RunSyncs(ctx, syncs, false)
 	}
+}
 
-	go func() {
-		for data = range configs {
-			if err = process(data); err != nil {
-				log.Fatal(err)
+func handleRequest(ctx context.Context, req *internal.Request, currentSyncs func() []*internal.Sync) {
+	res := &internal.Response{ID: req.ID}
+
+	var syncs []*internal.Sync
+	var err error
+
+	if req.Config != nil {
+		syncs, err = req.ParseConfig()
+	} else {
+		switch cmd, args := req.ParseCommand(); cmd {
+		case "list":
+			res.Syncs = internal.ListSyncs(currentSyncs())
+			internal.WriteResponse(res)
+			return
+		case "status":
+			res.Results = internal.LastResults(currentSyncs())
+			internal.WriteResponse(res)
+			return
+		case "run":
+			syncs, err = selectSyncs(currentSyncs(), args)
+		case "code":
+			// code {account} {code} or code {code}
+			if n := len(args); n == 0 || !internal.AnswerPrompt(strings.Join(args[:n-1], " "), args[n-1]) {
+				res.Error = "no waiting prompt"
 			}
+			internal.WriteResponse(res)
+			return
+		default:
+			err = errors.New("unknown command: " + req.Command)
 		}
+	}
+
+	if err != nil {
+		res.Error = err.Error()
+		internal.WriteResponse(res)
+		return
+	}
+
+	go func() {
+		res.Results = internal.RunSyncs(ctx, syncs, true)
+		internal.WriteResponse(res)
 	}()
+}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	fmt.Printf("exit with signal: %s\n", <-sigs)
+// selectSyncs returns selected syncs with dependencies or all syncs if names are empty
+func selectSyncs(syncs []*internal.Sync, names []string) ([]*internal.Sync, error) {
+	if len(names) == 0 {
+		return syncs, nil
+	}
+
+	for _, name := range names {
+		if !slices.ContainsFunc(syncs, func(sync *internal.Sync) bool {
+			return sync.Name == name
+		}) {
+			return nil, errors.New("unknown sync: " + name)
+		}
+	}
+
+	return internal.SelectSyncs(syncs, names...), nil
 }
 
 const configName = "scaleconnect.yaml"
 
-func readConfig(name string) ([]byte, error) {
+// readConfig returns config data and config file path (empty for raw config)
+func readConfig(name string) ([]byte, string, error) {
 	if name != "" {
 		// 1. Check if JSON passed as config
 		if name[0] == '{' {
-			return []byte(name), nil
+			return []byte(name), "", nil
 		}
 
 		// 2. Check config from passed path
-		return os.ReadFile(name)
+		data, err := os.ReadFile(name)
+		return data, name, err
 	}
 
 	// 3. Check config file in CWD
 	if data, err := os.ReadFile(configName); err == nil {
-		return data, nil
+		return data, configName, nil
 	}
 
 	// 4. Check config near binary
 	ex, err := os.Executable()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	path := filepath.Dir(ex)
 
 	data, err := os.ReadFile(filepath.Join(path, configName))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// change CWD so json file will be near app
-	return data, os.Chdir(path)
+	return data, configName, os.Chdir(path)
 }
 
-func process(data []byte) error {
-	var syncs map[string]struct {
-		From any               `yaml:"from"`
-		To   string            `yaml:"to"`
-		Expr map[string]string `yaml:"expr"`
-	}
-	if err := yaml.Unmarshal(data, &syncs); err != nil {
-		return err
+// watchConfig checks config file modification time every few seconds
+func watchConfig(path string, reload chan<- struct{}) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
 	}
 
-	for name, v := range syncs {
-		if v.From == "" || v.To == "" {
+	for range time.Tick(5 * time.Second) {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
 			continue
 		}
 
-		weights, err := internal.GetWeights(v.From)
-		if err != nil {
-			log.Printf("%s: load data error: %v\n", name, err)
-			continue
+		modTime = info.ModTime()
+
+		select {
+		case reload <- struct{}{}:
+		default:
 		}
+	}
+}
 
-		if v.Expr != nil {
-			if err = internal.Expr(v.Expr, weights); err != nil {
-				log.Printf("%s: calc expr error: %v\n", name, err)
-				continue
-			}
+func reloadConfig(path string, oldSyncs []*internal.Sync) ([]*internal.Sync, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	newSyncs, err := internal.ParseSyncs(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var changes []string
+
+	for _, newSync := range newSyncs {
+		i := slices.IndexFunc(oldSyncs, func(sync *internal.Sync) bool {
+			return sync.Name == newSync.Name
+		})
+		if i < 0 {
+			changes = append(changes, "+"+newSync.Name)
+		} else if !reflect.DeepEqual(oldSyncs[i], newSync) {
+			changes = append(changes, "~"+newSync.Name)
 		}
+	}
 
-		if err = internal.SetWeights(v.To, weights); err != nil {
-			log.Printf("%s: write data error: %v\n", name, err)
-			continue
+	for _, oldSync := range oldSyncs {
+		if !slices.ContainsFunc(newSyncs, func(sync *internal.Sync) bool {
+			return sync.Name == oldSync.Name
+		}) {
+			changes = append(changes, "-"+oldSync.Name)
 		}
+	}
+
+	if changes == nil {
+		return nil, false, nil
+	}
+
+	log.Printf("config: reload, changes: %s\n", strings.Join(changes, " "))
+
+	return newSyncs, true, nil
+}
+
+func discover(ctx context.Context, fields []string) {
+	scales, err := internal.Discover(ctx, fields)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		log.Printf("%s: OK\n", name)
+	if len(scales) == 0 {
+		log.Println("scales not found")
+		return
 	}
 
-	return nil
+	for _, scale := range scales {
+		fmt.Printf("%s %s %q did=%s\n", scale.Region, scale.Model, scale.Name, scale.DID)
+		if scale.Region == "cn" {
+			fmt.Printf("  from: mifitness {username} {password} %s\n", scale.Model)
+		} else {
+			fmt.Printf("  from: xiaomihome {username} {password} %s %s\n", scale.Region, scale.Model)
+		}
+	}
 }
@@ -1,22 +1,38 @@
 package core
 
+import (
+	"context"
+)
+
 type Account interface {
-	Login(username, password string) error
-	GetAllWeights() ([]*Weight, error)
+	Login(ctx context.Context, username, password string) error
+	GetAllWeights(ctx context.Context) ([]*Weight, error)
 }
 
 type AccountWithToken interface {
 	Account
-	LoginWithToken(token string) error
+	LoginWithToken(ctx context.Context, token string) error
 	Token() string
 }
 
 type AccountWithFilter interface {
-	GetFilterWeights(name string) ([]*Weight, error)
+	GetFilterWeights(ctx context.Context, name string) ([]*Weight, error)
 }
 
 type AccountWithAddWeights interface {
-	AddWeights(weights []*Weight) error
-	DeleteWeight(weight *Weight) error
+	AddWeights(ctx context.Context, weights []*Weight, opts Options) error
+	DeleteWeight(ctx context.Context, weight *Weight, opts Options) error
 	Equal(a, b *Weight) bool
 }
+
+// Options - destination options for one upload, so the shared account client isn't changed
+type Options struct {
+	Region  string             // Mi Fitness region, empty for China
+	Members map[string]*Member // family members allowed to be created, by nickname
+}
+
+type Member struct {
+	Birthday string `yaml:"birthday"` // YYYY-MM
+	Gender   string `yaml:"gender"`   // male or female
+	Height   int    `yaml:"height"`   // cm
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ErrUnauthorized - session or token is no longer valid, new login required
+var ErrUnauthorized = errors.New("unauthorized")
+
+// StatusError - server response with unexpected status code
+type StatusError struct {
+	Prefix     string
+	Status     string
+	StatusCode int
+}
+
+func NewStatusError(prefix string, res *http.Response) *StatusError {
+	return &StatusError{Prefix: prefix, Status: res.Status, StatusCode: res.StatusCode}
+}
+
+func (e *StatusError) Error() string {
+	return e.Prefix + e.Status
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnauthorized && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
+// FailedWeight - weighing that was not saved to destination
+type FailedWeight struct {
+	Date  time.Time `json:"date"`
+	Error string    `json:"error"`
+}
+
+// WeightsError - some weighings were not saved, other weighings are OK
+type WeightsError struct {
+	Prefix string
+	Failed []*FailedWeight
+}
+
+func (e *WeightsError) Error() string {
+	return fmt.Sprintf("%s%d weighings failed", e.Prefix, len(e.Failed))
+}
+
+// IsTransient returns true for network errors, server errors and too many requests
+func IsTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	// http.Client wraps all network errors in url.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -1,7 +1,10 @@
 package core
 
 import (
+	"context"
 	"crypto/rand"
+	"io"
+	"net/http"
 	"strings"
 )
 
@@ -36,3 +39,22 @@ func Between(s, sub1, sub2 string) string {
 
 	return s
 }
+
+// Get - same as http.Client.Get but with context
+func Get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// Post - same as http.Client.Post but with context
+func Post(ctx context.Context, client *http.Client, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return client.Do(req)
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TOTP returns 6 digits one-time code for base32 secret (RFC 6238)
+func TOTP(secret string, t time.Time) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("totp: wrong secret: %w", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(t.Unix()/30))
+
+	hasher := hmac.New(sha1.New, key)
+	hasher.Write(msg)
+	sum := hasher.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF
+
+	return fmt.Sprintf("%06d", code%1_000_000), nil
+}
@@ -1,13 +1,16 @@
 package core
 
 import (
+	"fmt"
+	"sync"
 	"time"
 )
 
 type Weight struct {
 	// main data
 	Date   time.Time `json:"Date"`
-	Weight float32   `json:"Weight"` // kg
+	Weight float32   `json:"Weight"`         // kg
+	Zone   string    `json:"Zone,omitempty"` // IANA name or UTC offset (+03:00) of measurement place
 
 	// almost all scales
 	BMI       float32 `json:"BMI,omitempty"`
@@ -29,6 +32,10 @@ type Weight struct {
 	Height             float32 `json:"Height,omitempty"`             // cm
 	SkeletalMuscleMass float32 `json:"SkeletalMuscleMass,omitempty"` // kg
 
+	// raw data
+	Impedance     float32 `json:"Impedance,omitempty"`     // ohm
+	ImpedanceHigh float32 `json:"ImpedanceHigh,omitempty"` // ohm, high frequency for dual-frequency scales
+
 	User   string `json:"User,omitempty"`
 	Source string `json:"Source,omitempty"`
 
@@ -54,5 +61,48 @@ func Equal(w1, w2 *Weight) bool {
 		w1.BodyScore == w2.BodyScore &&
 		w1.HeartRate == w2.HeartRate &&
 		w1.Height == w2.Height &&
-		w1.SkeletalMuscleMass == w2.SkeletalMuscleMass
+		w1.SkeletalMuscleMass == w2.SkeletalMuscleMass &&
+		w1.Impedance == w2.Impedance &&
+		w1.ImpedanceHigh == w2.ImpedanceHigh
+}
+
+var locations sync.Map
+
+// Location returns time zone of measurement place, or local time zone if unknown
+func (w *Weight) Location() *time.Location {
+	if w.Zone == "" {
+		return time.Local
+	}
+
+	if loc, ok := locations.Load(w.Zone); ok {
+		return loc.(*time.Location)
+	}
+
+	loc, err := time.LoadLocation(w.Zone)
+	if err != nil {
+		// UTC offset: +03:00
+		t, err := time.Parse("-07:00", w.Zone)
+		if err != nil {
+			return time.Local
+		}
+		_, offset := t.Zone()
+		loc = time.FixedZone(w.Zone, offset)
+	}
+
+	locations.Store(w.Zone, loc)
+	return loc
+}
+
+// LocalDate returns date in time zone of measurement place
+func (w *Weight) LocalDate() time.Time {
+	return w.Date.In(w.Location())
+}
+
+// OffsetZone converts UTC offset in seconds to zone: +03:00
+func OffsetZone(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, offset/3600, offset/60%60)
 }
@@ -14,9 +14,11 @@ const Header = "Date,Weight," +
 	"BMI,BodyFat,BodyWater,BoneMass," +
 	"MetabolicAge,MuscleMass,PhysiqueRating,ProteinMass,VisceralFat," +
 	"BasalMetabolism,HeartRate,SkeletalMuscleMass," +
-	"User,Source\n"
+	"User,Source," +
+	"Zone,Impedance,ImpedanceHigh\n" // new columns only at the end, some consumers read columns by position
 
-func Read(r io.Reader) ([]*core.Weight, error) {
+// Read weighings from CSV. Local - dates are in time zone of measurement place (Zone column).
+func Read(r io.Reader, local bool) ([]*core.Weight, error) {
 	cr := csv.NewReader(r)
 	header, err := cr.Read()
 	if err != nil {
@@ -35,11 +37,12 @@ func Read(r io.Reader) ([]*core.Weight, error) {
 		}
 
 		var w core.Weight
+		var date string
 
 		for i, s := range header {
 			switch s {
 			case "Date":
-				w.Date = parseDate(record[i])
+				date = record[i]
 			case "Weight":
 				w.Weight = parseFloat(record[i])
 			case "BMI":
@@ -66,21 +69,33 @@ func Read(r io.Reader) ([]*core.Weight, error) {
 				w.HeartRate = parseInt(record[i])
 			case "SkeletalMuscleMass":
 				w.SkeletalMuscleMass = parseFloat(record[i])
+			case "Impedance":
+				w.Impedance = parseFloat(record[i])
+			case "ImpedanceHigh":
+				w.ImpedanceHigh = parseFloat(record[i])
 			case "User":
 				w.User = record[i]
 			case "Source":
 				w.Source = record[i]
+			case "Zone":
+				w.Zone = record[i]
 			}
 		}
 
+		if local {
+			w.Date = parseDate(date, w.Location())
+		} else {
+			w.Date = parseDate(date, time.Local)
+		}
+
 		weights = append(weights, &w)
 	}
 
 	return weights, nil
 }
 
-func parseDate(s string) time.Time {
-	t, _ := time.ParseInLocation(time.DateTime, s, time.Local) // local time!!!
+func parseDate(s string, loc *time.Location) time.Time {
+	t, _ := time.ParseInLocation(time.DateTime, s, loc) // local time!!!
 	return t
 }
 
@@ -94,22 +109,27 @@ func parseInt(s string) int {
 	return i
 }
 
-func Write(w io.Writer, weights []*core.Weight) error {
+// Write weighings to CSV. Local - dates are in time zone of measurement place, if it is known.
+func Write(w io.Writer, weights []*core.Weight, local bool) error {
 	if _, err := w.Write([]byte(Header)); err != nil {
 		return err
 	}
 	for _, weight := range weights {
-		if _, err := w.Write(Marshal(weight)); err != nil {
+		if _, err := w.Write(Marshal(weight, local)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func Marshal(weight *core.Weight) []byte {
+func Marshal(weight *core.Weight, local bool) []byte {
 	b := make([]byte, 0, 128)
 
-	b = appendDate(b, weight.Date)
+	if local {
+		b = appendDate(b, weight.LocalDate())
+	} else {
+		b = appendDate(b, weight.Date)
+	}
 	b = appendFloat(b, weight.Weight)
 
 	b = appendFloat(b, weight.BMI)
@@ -130,6 +150,10 @@ func Marshal(weight *core.Weight) []byte {
 	b = appendString(b, weight.User)
 	b = appendString(b, weight.Source)
 
+	b = appendString(b, weight.Zone)
+	b = appendFloat(b, weight.Impedance)
+	b = appendFloat(b, weight.ImpedanceHigh)
+
 	return append(b, '\n')
 }
 
@@ -1,6 +1,7 @@
 package garmin
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,38 +15,33 @@ import (
 	"github.com/gomodule/oauth1/oauth"
 )
 
-func (c *Client) Login(username, password string) error {
-	ticket, err := c.getTicket(username, password)
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	ticket, err := c.getTicket(ctx, username, password)
 	if err != nil {
 		return err
 	}
-	return c.getCredentials(ticket)
+	return c.getCredentials(ctx, ticket)
 }
 
 // getTicket - first stage exchange username and password to OAuth ticket
-func (c *Client) getTicket(username, password string) (string, error) {
-	const url1 = "https://sso.garmin.com/sso/embed?" +
+func (c *Client) getTicket(ctx context.Context, username, password string) (string, error) {
+	sso := "https://sso." + c.domain + "/sso"
+
+	url1 := sso + "/embed?" +
 		"id=gauth-widget&" +
 		"embedWidget=true&" +
-		"gauthHost=https://sso.garmin.com/sso"
+		"gauthHost=" + sso
 
-	res, err := c.client.Get(url1)
+	res, err := core.Get(ctx, c.client, url1)
 	if err != nil {
 		return "", err
 	}
 	defer res.Body.Close()
 
 	// 2. Get CSRF
-	const url2 = "https://sso.garmin.com/sso/signin?" +
-		"id=gauth-widget&" +
-		"embedWidget=true&" +
-		"gauthHost=https://sso.garmin.com/sso/embed&" +
-		"redirectAfterAccountCreationUrl=https://sso.garmin.com/sso/embed&" +
-		"redirectAfterAccountLoginUrl=https://sso.garmin.com/sso/embed&" +
-		"service=https://sso.garmin.com/sso/embed&" +
-		"source=https://sso.garmin.com/sso/embed"
+	url2 := sso + "/signin?" + c.signinParams()
 
-	res, err = c.client.Get(url2)
+	res, err = core.Get(ctx, c.client, url2)
 	if err != nil {
 		return "", err
 	}
@@ -61,7 +57,7 @@ func (c *Client) getTicket(username, password string) (string, error) {
 	// 3. Signin
 	data := fmt.Sprintf("username=%s&password=%s&embed=true&_csrf=%s", username, password, csrf)
 
-	req, err := http.NewRequest("POST", url2, strings.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", url2, strings.NewReader(data))
 	if err != nil {
 		return "", err
 	}
@@ -80,6 +76,13 @@ func (c *Client) getTicket(username, password string) (string, error) {
 		return "", err
 	}
 
+	// 4. Two-factor authentication
+	if title := core.Between(string(body), "<title>", "</title>"); strings.Contains(title, "MFA") {
+		if body, err = c.verifyMFA(ctx, string(body)); err != nil {
+			return "", err
+		}
+	}
+
 	ticket := core.Between(string(body), `embed?ticket=`, `"`)
 	if ticket == "" {
 		return "", errors.New("garmin: can't find ticket")
@@ -88,12 +91,69 @@ func (c *Client) getTicket(username, password string) (string, error) {
 	return ticket, nil
 }
 
-func (c *Client) initOAuth() error {
+func (c *Client) signinParams() string {
+	embed := "https://sso." + c.domain + "/sso/embed"
+	return "id=gauth-widget&" +
+		"embedWidget=true&" +
+		"gauthHost=" + embed + "&" +
+		"redirectAfterAccountCreationUrl=" + embed + "&" +
+		"redirectAfterAccountLoginUrl=" + embed + "&" +
+		"service=" + embed + "&" +
+		"source=" + embed
+}
+
+// verifyMFA - send one-time code from MFA page and return page with ticket
+func (c *Client) verifyMFA(ctx context.Context, page string) ([]byte, error) {
+	if c.MFA == nil {
+		return nil, errors.New("garmin: MFA code required")
+	}
+
+	code, err := c.MFA(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"mfa-code": {code},
+		"embed":    {"true"},
+		"_csrf":    {core.Between(page, `name="_csrf" value="`, `"`)},
+		"fromPage": {"setupEnterMfaCode"},
+	}
+
+	url1 := "https://sso." + c.domain + "/sso/verifyMFA/loginEnterMfaCode?" + c.signinParams()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url1, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", "https://sso."+c.domain+"/sso/signin?"+c.signinParams())
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if title := core.Between(string(body), "<title>", "</title>"); strings.Contains(title, "MFA") {
+		return nil, errors.New("garmin: wrong MFA code")
+	}
+
+	return body, nil
+}
+
+func (c *Client) initOAuth(ctx context.Context) error {
 	if c.oauthClient != nil {
 		return nil
 	}
 
-	res, err := http.Get("https://thegarth.s3.amazonaws.com/oauth_consumer.json")
+	res, err := core.Get(ctx, http.DefaultClient, "https://thegarth.s3.amazonaws.com/oauth_consumer.json")
 	if err != nil {
 		return err
 	}
@@ -119,20 +179,20 @@ func (c *Client) initOAuth() error {
 }
 
 // getCredentials - first stage exchange ticket to OAuth Token and Secret
-func (c *Client) getCredentials(ticket string) error {
-	if err := c.initOAuth(); err != nil {
+func (c *Client) getCredentials(ctx context.Context, ticket string) error {
+	if err := c.initOAuth(ctx); err != nil {
 		return err
 	}
 
 	url1 := fmt.Sprintf(
-		"https://connectapi.garmin.com/oauth-service/oauth/preauthorized?"+
+		"https://connectapi.%s/oauth-service/oauth/preauthorized?"+
 			"ticket=%s&"+
-			"login-url=https://sso.garmin.com/sso/embed&"+
+			"login-url=https://sso.%s/sso/embed&"+
 			"accepts-mfa-tokens=true",
-		ticket,
+		c.domain, ticket, c.domain,
 	)
 
-	req, err := http.NewRequest("GET", url1, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url1, nil)
 	if err != nil {
 		return err
 	}
@@ -164,14 +224,14 @@ func (c *Client) getCredentials(ticket string) error {
 }
 
 // refreshAccessToken - exchange OAuth Token and Secret to accessToken
-func (c *Client) refreshAccessToken() error {
-	if err := c.initOAuth(); err != nil {
+func (c *Client) refreshAccessToken(ctx context.Context) error {
+	if err := c.initOAuth(ctx); err != nil {
 		return err
 	}
 
-	const url1 = "https://connectapi.garmin.com/oauth-service/oauth/exchange/user/2.0"
+	url1 := "https://connectapi." + c.domain + "/oauth-service/oauth/exchange/user/2.0"
 
-	req, err := http.NewRequest("POST", url1, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url1, nil)
 	if err != nil {
 		return err
 	}
@@ -189,6 +249,10 @@ func (c *Client) refreshAccessToken() error {
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return core.NewStatusError("garmin: ", res)
+	}
+
 	var data struct {
 		AccessToken string `json:"access_token"`
 		ExpiresIn   int    `json:"expires_in"`
@@ -204,28 +268,62 @@ func (c *Client) refreshAccessToken() error {
 	return nil
 }
 
+// getAccessToken returns valid access token, it's safe for parallel requests
+func (c *Client) getAccessToken(ctx context.Context, refresh bool) (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if refresh || c.accessToken == "" || time.Now().After(c.expiresTime) {
+		if err := c.refreshAccessToken(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	return c.accessToken, nil
+}
+
 func (c *Client) do(req *http.Request) (*http.Response, error) {
-	if c.accessToken == "" || time.Now().After(c.expiresTime) {
-		if err := c.refreshAccessToken(); err != nil {
+	token, err := c.getAccessToken(req.Context(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := c.client.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// access token may be revoked before expiration time, so refresh it and repeat request once
+	_ = res.Body.Close()
+
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
 			return nil, err
 		}
 	}
 
-	req.Header.Add("Authorization", "Bearer "+c.accessToken)
+	if token, err = c.getAccessToken(req.Context(), true); err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
 	return c.client.Do(req)
 }
 
-func (c *Client) LoginWithToken(token string) error {
+func (c *Client) LoginWithToken(ctx context.Context, token string) error {
 	c.oauthToken, c.oauthSecret, _ = strings.Cut(token, ":")
+	c.accessToken = ""
 
-	res, err := c.Get("userprofile-service/userprofile/userProfileBase")
+	res, err := c.Get(ctx, "userprofile-service/userprofile/userProfileBase")
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("garmin: can't login")
+		return core.NewStatusError("garmin: can't login: ", res)
 	}
 
 	return nil
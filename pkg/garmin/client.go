@@ -2,12 +2,16 @@ package garmin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
@@ -17,6 +21,7 @@ import (
 
 type Client struct {
 	client *http.Client
+	domain string // garmin.com or garmin.cn
 
 	oauthToken  string
 	oauthSecret string
@@ -24,35 +29,47 @@ type Client struct {
 	oauthClient *oauth.Client
 	accessToken string
 	expiresTime time.Time
+	tokenMu     sync.Mutex
 
 	weightID map[int64]string
+	mu       sync.Mutex
+
+	// MFA returns one-time code for accounts with two-factor authentication
+	MFA func(ctx context.Context) (string, error)
 }
 
-func NewClient() *Client {
+// NewClient - region can be empty (global) or "cn" (China)
+func NewClient(region string) *Client {
+	domain := "garmin.com"
+	if region == "cn" {
+		domain = "garmin.cn"
+	}
+
 	jar, _ := cookiejar.New(nil)
 	return &Client{
 		client:   &http.Client{Timeout: time.Minute, Jar: jar},
+		domain:   domain,
 		weightID: make(map[int64]string),
 	}
 }
 
-func (c *Client) Get(api string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", "https://connectapi.garmin.com/"+api, nil)
+func (c *Client) Get(ctx context.Context, api string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://connectapi."+c.domain+"/"+api, nil)
 	if err != nil {
 		return nil, err
 	}
 	return c.do(req)
 }
 
-func (c *Client) Delete(api string) (*http.Response, error) {
-	req, err := http.NewRequest("DELETE", "https://connectapi.garmin.com/"+api, nil)
+func (c *Client) Delete(ctx context.Context, api string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", "https://connectapi."+c.domain+"/"+api, nil)
 	if err != nil {
 		return nil, err
 	}
 	return c.do(req)
 }
 
-func (c *Client) PostFile(api, filename string, data []byte) (*http.Response, error) {
+func (c *Client) PostFile(ctx context.Context, api, filename string, data []byte) (*http.Response, error) {
 	buf := bytes.NewBuffer(nil)
 	w := multipart.NewWriter(buf)
 	part, err := w.CreateFormFile("file", filename)
@@ -68,7 +85,7 @@ func (c *Client) PostFile(api, filename string, data []byte) (*http.Response, er
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://connectapi.garmin.com/"+api, buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://connectapi."+c.domain+"/"+api, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -77,33 +94,198 @@ func (c *Client) PostFile(api, filename string, data []byte) (*http.Response, er
 	return c.do(req)
 }
 
-func (c *Client) Upload(filename string, data []byte) error {
-	res, err := c.PostFile("upload-service/upload", filename, data)
+type uploadResult struct {
+	DetailedImportResult struct {
+		UploadUuid *struct {
+			Uuid string `json:"uuid"`
+		} `json:"uploadUuid"`
+		CreationDate string `json:"creationDate"` // 2025-08-01 09:00:00.0 GMT
+		Failures     []struct {
+			Messages []struct {
+				Content string `json:"content"` // Duplicate Activity.
+			} `json:"messages"`
+		} `json:"failures"`
+	} `json:"detailedImportResult"`
+}
+
+func (r *uploadResult) err() error {
+	for _, failure := range r.DetailedImportResult.Failures {
+		for _, msg := range failure.Messages {
+			return errors.New("garmin: upload error: " + msg.Content)
+		}
+		return errors.New("garmin: upload error")
+	}
+	return nil
+}
+
+// Upload sends file and waits until it is processed by server
+func (c *Client) Upload(ctx context.Context, filename string, data []byte) error {
+	res, err := c.PostFile(ctx, "upload-service/upload", filename, data)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusCreated {
-		return errors.New(res.Status)
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusAccepted {
+		return core.NewStatusError("garmin: upload error: ", res)
+	}
+
+	var upload uploadResult
+	if err = json.NewDecoder(res.Body).Decode(&upload); err != nil {
+		return err
+	}
+
+	if err = upload.err(); err != nil {
+		return err
+	}
+
+	return c.waitUpload(ctx, &upload)
+}
+
+// waitUpload checks upload status until processing finish
+func (c *Client) waitUpload(ctx context.Context, upload *uploadResult) error {
+	result := upload.DetailedImportResult
+	if result.UploadUuid == nil {
+		return nil // nothing to check
+	}
+
+	created, err := time.Parse("2006-01-02 15:04:05.0 MST", result.CreationDate)
+	if err != nil {
+		return nil // nothing to check
+	}
+
+	path := fmt.Sprintf("activity-service/activity/status/%d/%s", created.UnixMilli(), result.UploadUuid.Uuid)
+
+	for i := 0; i < 30; i++ {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		res, err := c.Get(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		switch res.StatusCode {
+		case http.StatusAccepted:
+			_ = res.Body.Close()
+			continue // still processing
+		case http.StatusOK, http.StatusCreated:
+		default:
+			_ = res.Body.Close()
+			return core.NewStatusError("garmin: upload status error: ", res)
+		}
+
+		var status uploadResult
+		err = json.NewDecoder(res.Body).Decode(&status)
+		_ = res.Body.Close()
+		if err != nil {
+			return err
+		}
+		return status.err()
 	}
 
+	// weighings will be checked anyway after upload
 	return nil
 }
 
-func (c *Client) GetAllWeights() ([]*core.Weight, error) {
-	return c.GetWeight("1970-01-01", time.Now().Format(time.DateOnly))
+// max parallel requests for history download
+const downloadWorkers = 4
+
+// GetAllWeights downloads history by years, starting from the first weigh-in
+func (c *Client) GetAllWeights(ctx context.Context) ([]*core.Weight, error) {
+	start, err := c.getFirstDate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.getRangeWeights(ctx, start, time.Now())
+}
+
+// getRangeWeights downloads weighings by years in parallel, because Garmin fails on big ranges
+func (c *Client) getRangeWeights(ctx context.Context, start, end time.Time) ([]*core.Weight, error) {
+	var windows [][2]string
+
+	for year := start.Year(); year <= end.Year(); year++ {
+		last := time.Date(year, 12, 31, 0, 0, 0, 0, time.Local)
+		if last.After(end) {
+			last = end
+		}
+		windows = append(windows, [2]string{start.Format(time.DateOnly), last.Format(time.DateOnly)})
+		start = time.Date(year+1, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+
+	chunks := make([][]*core.Weight, len(windows))
+	errs := make([]error, len(windows))
+
+	sem := make(chan struct{}, downloadWorkers)
+
+	var wg sync.WaitGroup
+	for i, window := range windows {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			chunks[i], errs[i] = c.GetWeight(ctx, window[0], window[1])
+		}()
+	}
+	wg.Wait()
+
+	// partial history is worse than error, because sync may upload duplicates
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return slices.Concat(chunks...), nil
+}
+
+// getFirstDate returns date of the first weigh-in
+func (c *Client) getFirstDate(ctx context.Context) (time.Time, error) {
+	res, err := c.Get(ctx, "weight-service/weight/range/1970-01-01/1970-01-01?includeAll=true")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return time.Time{}, core.NewStatusError("garmin: ", res)
+	}
+
+	var data struct {
+		NextDateWeight struct {
+			CalendarDate string `json:"calendarDate"` // 2025-07-26
+		} `json:"nextDateWeight"`
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return time.Time{}, err
+	}
+
+	// undocumented field, so load all history without it,
+	// empty account is detected by empty weighings list
+	if data.NextDateWeight.CalendarDate == "" {
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, time.Local), nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, data.NextDateWeight.CalendarDate, time.Local)
 }
 
 // GetWeight - start and end format: 2025-07-28
-func (c *Client) GetWeight(start, end string) ([]*core.Weight, error) {
+func (c *Client) GetWeight(ctx context.Context, start, end string) ([]*core.Weight, error) {
 	path := fmt.Sprintf("weight-service/weight/range/%s/%s?includeAll=true", start, end)
-	res, err := c.Get(path)
+	res, err := c.Get(ctx, path)
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode != http.StatusOK {
+		return nil, core.NewStatusError("garmin: ", res)
+	}
+
 	var data struct {
 		DailyWeightSummaries []struct {
 			AllWeightMetrics []struct {
@@ -132,6 +314,8 @@ func (c *Client) GetWeight(start, end string) ([]*core.Weight, error) {
 
 	var weights []*core.Weight
 
+	weightID := make(map[int64]string)
+
 	for _, day := range data.DailyWeightSummaries {
 		for _, metric := range day.AllWeightMetrics {
 			ts := metric.TimestampGMT
@@ -140,7 +324,7 @@ func (c *Client) GetWeight(start, end string) ([]*core.Weight, error) {
 			}
 
 			// important for delete function
-			c.weightID[ts] = fmt.Sprintf("%s/byversion/%d", metric.CalendarDate, metric.SamplePk)
+			weightID[ts] = fmt.Sprintf("%s/byversion/%d", metric.CalendarDate, metric.SamplePk)
 
 			w := &core.Weight{
 				Date:   time.UnixMilli(ts),
@@ -159,54 +343,119 @@ func (c *Client) GetWeight(start, end string) ([]*core.Weight, error) {
 
 				Source: metric.SourceType,
 			}
+			// date is local time of the weighing
+			if metric.TimestampGMT != 0 && metric.Date != 0 {
+				w.Zone = core.OffsetZone(int(metric.Date-metric.TimestampGMT) / 60000 * 60)
+			}
 			weights = append(weights, w)
 		}
 	}
+
+	c.mu.Lock()
+	maps.Copy(c.weightID, weightID)
+	c.mu.Unlock()
+
 	return weights, nil
 }
 
-func (c *Client) AddWeights(weights []*core.Weight) error {
-	if len(c.weightID) == 0 {
+func (c *Client) AddWeights(ctx context.Context, weights []*core.Weight, _ core.Options) error {
+	c.mu.Lock()
+	n := len(c.weightID)
+	c.mu.Unlock()
+
+	if n == 0 {
 		return nil
 	}
 
-	for len(weights) != 0 {
-		var chunk []*core.Weight
-
-		// Garmin fails on big files
-		if len(weights) > 200 {
-			chunk = weights[:200]
-			weights = weights[200:]
-		} else {
-			chunk = weights
-			weights = nil
-		}
+	failed := map[int64]string{}
 
+	// Garmin fails on big files
+	for i, chunk := range slices.Collect(slices.Chunk(weights, 200)) {
 		buf := bytes.NewBuffer(nil)
 		if err := fit.WriteWeight(buf, chunk...); err != nil {
 			return err
 		}
 
-		if err := c.Upload("new.fit", buf.Bytes()); err != nil {
+		if err := c.Upload(ctx, "new.fit", buf.Bytes()); err != nil {
+			// nothing uploaded yet, so whole sync can be repeated
+			if i == 0 && (core.IsTransient(err) || errors.Is(err, core.ErrUnauthorized)) {
+				return err
+			}
+			for _, w := range chunk {
+				failed[w.Date.Unix()] = err.Error()
+			}
+		}
+	}
+
+	return c.verifyWeights(ctx, weights, failed)
+}
+
+// verifyWeights reads uploaded weighings from server and reports missing ones
+func (c *Client) verifyWeights(ctx context.Context, weights []*core.Weight, failed map[int64]string) error {
+	start := slices.MinFunc(weights, func(a, b *core.Weight) int { return a.Date.Compare(b.Date) }).Date
+	end := slices.MaxFunc(weights, func(a, b *core.Weight) int { return a.Date.Compare(b.Date) }).Date
+
+	// Garmin uses calendar date from user profile time zone
+	start = start.AddDate(0, 0, -1)
+	end = end.AddDate(0, 0, 1)
+
+	var missing []*core.Weight
+
+	// server may need some time to show new weighings
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		dst, err := c.getRangeWeights(ctx, start, end)
+		if err != nil {
 			return err
 		}
+
+		missing = slices.DeleteFunc(slices.Clone(weights), func(w *core.Weight) bool {
+			return slices.ContainsFunc(dst, func(d *core.Weight) bool {
+				return d.Date.Unix() == w.Date.Unix()
+			})
+		})
+		if len(missing) == 0 {
+			return nil
+		}
 	}
 
-	return nil
+	err := &core.WeightsError{Prefix: "garmin: "}
+	for _, w := range missing {
+		msg, ok := failed[w.Date.Unix()]
+		if !ok {
+			msg = "not found after upload"
+		}
+		err.Failed = append(err.Failed, &core.FailedWeight{Date: w.Date, Error: msg})
+	}
+	return err
 }
 
-func (c *Client) DeleteWeight(weight *core.Weight) error {
+func (c *Client) DeleteWeight(ctx context.Context, weight *core.Weight, _ core.Options) error {
+	c.mu.Lock()
 	weightID, ok := c.weightID[weight.Date.UnixMilli()]
+	c.mu.Unlock()
+
 	if !ok {
 		return errors.New("garmin: weight not exist")
 	}
 
-	res, err := c.Delete("weight-service/weight/" + weightID)
+	res, err := c.Delete(ctx, "weight-service/weight/"+weightID)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode >= 300 {
+		return core.NewStatusError("garmin: delete weight error: ", res)
+	}
+
 	return nil
 }
 
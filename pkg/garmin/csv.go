@@ -0,0 +1,265 @@
+package garmin
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+)
+
+// csvColumns - localized Garmin Connect column names (en, de, fr, es, it, pt, nl, pl, ru, zh).
+// First column is always date or time. Order is important, because weight is the most common word.
+var csvColumns = []struct {
+	name  string
+	words []string
+}{
+	{"change", []string{"change", "änderung", "variation", "cambio", "variazione", "alteração", "verandering", "zmiana", "изменение", "变化"}},
+	{"bmi", []string{"bmi", "imc", "имт"}},
+	{"fat", []string{"fat", "fett", "grasse", "grasa", "grassa", "gordura", "vet", "tłuszcz", "жир", "脂"}},
+	{"muscle", []string{"muscle", "muskel", "muscula", "muscolare", "spier", "mięśni", "мышеч", "肌"}},
+	{"bone", []string{"bone", "knochen", "osseuse", "ósea", "ossea", "óssea", "bot", "kost", "кост", "骨"}},
+	{"water", []string{"water", "wasser", "eau", "hydrat", "agua", "acqua", "água", "vocht", "woda", "вод", "水"}},
+	{"weight", []string{"weight", "gewicht", "poids", "peso", "waga", "вес", "体重"}},
+}
+
+// ReadCSV reads weighings from Garmin Connect web export (Health Stats - Weight - Export).
+// Date rows are followed by time rows with values, values can have units: "72.5 kg", "160 lbs", "18.2 %".
+func ReadCSV(r io.Reader) ([]*core.Weight, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = -1
+	rd.LazyQuotes = true
+	rd.TrimLeadingSpace = true
+
+	header, err := rd.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	for i := 1; i < len(header); i++ {
+		s := strings.ToLower(strings.TrimSpace(header[i]))
+		for _, col := range csvColumns {
+			if containsAny(s, col.words) {
+				columns[i] = col.name
+				break
+			}
+		}
+	}
+
+	if !strings.Contains(strings.Join(columns, ","), "weight") {
+		return nil, errors.New("garmin: unknown CSV format")
+	}
+
+	var weights []*core.Weight
+	var date time.Time
+
+	for {
+		row, err := rd.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+
+		// row with full date and time
+		if ts, ok := parseDateTime(row[0]); ok {
+			date = ts
+			if !hasValues(row) {
+				continue // date row
+			}
+		} else if ts, ok := parseTime(row[0]); ok && !date.IsZero() {
+			date = withClock(date, ts)
+		} else {
+			return nil, errors.New("garmin: unknown date format: " + row[0])
+		}
+
+		w := &core.Weight{Date: date, Source: "garmin-csv"}
+
+		for i, s := range row {
+			if i >= len(columns) {
+				break
+			}
+			switch columns[i] {
+			case "weight":
+				w.Weight = parseMass(s)
+			case "bmi":
+				w.BMI = parseFloat(s)
+			case "fat":
+				w.BodyFat = parseFloat(s)
+			case "muscle":
+				w.SkeletalMuscleMass = parseMass(s)
+			case "bone":
+				w.BoneMass = parseMass(s)
+			case "water":
+				w.BodyWater = parseFloat(s)
+			}
+		}
+
+		if w.Weight != 0 {
+			weights = append(weights, w)
+		}
+	}
+
+	return weights, nil
+}
+
+func containsAny(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasValues(row []string) bool {
+	for _, s := range row[1:] {
+		if parseFloat(s) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+var reNumber = regexp.MustCompile(`-?\d+(?:[.,]\d+)?`)
+
+func parseFloat(s string) float32 {
+	s = reNumber.FindString(s)
+	f, _ := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 32)
+	return float32(f)
+}
+
+// parseMass converts lbs to kg
+func parseMass(s string) float32 {
+	f := parseFloat(s)
+	if strings.Contains(strings.ToLower(s), "lb") {
+		return f * 0.45359237
+	}
+	return f
+}
+
+// months - first letters of localized month names
+var months = [12][]string{
+	{"jan", "ene", "gen", "sty", "янв"},
+	{"feb", "fév", "fev", "lut", "фев"},
+	{"mar", "mär", "maa", "мар"},
+	{"apr", "avr", "abr", "kwi", "апр"},
+	{"may", "mai", "mei", "mag", "maj", "мая", "май"},
+	{"jun", "juin", "giu", "cze", "июн"},
+	{"jul", "juil", "lug", "lip", "июл"},
+	{"aug", "aoû", "aou", "ago", "sie", "авг"},
+	{"sep", "set", "wrz", "сен"},
+	{"oct", "okt", "out", "ott", "paź", "paz", "окт"},
+	{"nov", "lis", "ноя"},
+	{"dec", "dez", "déc", "dic", "gru", "дек"},
+}
+
+var reDateNumbers = regexp.MustCompile(`\d+`)
+
+// parseDateTime supports: "Jul 26, 2025", "26. Juli 2025", "26 juil. 2025", "2025-07-26",
+// "26.07.2025", "07/26/2025", "2025年7月26日" and optional time after date
+func parseDateTime(s string) (time.Time, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	// split date and optional time
+	var clock string
+	if i := strings.IndexByte(s, ':'); i > 0 {
+		if j := strings.LastIndexByte(s[:i], ' '); j > 0 {
+			s, clock = s[:j], s[j+1:]
+		}
+	}
+
+	nums := reDateNumbers.FindAllString(s, -1)
+
+	month := findMonth(s)
+
+	var year, mon, day int
+
+	switch {
+	case month > 0 && len(nums) == 2:
+		a, _ := strconv.Atoi(nums[0])
+		b, _ := strconv.Atoi(nums[1])
+		if a > 31 {
+			year, day = a, b
+		} else {
+			day, year = a, b
+		}
+		mon = month
+	case month == 0 && len(nums) == 3:
+		a, _ := strconv.Atoi(nums[0])
+		b, _ := strconv.Atoi(nums[1])
+		c, _ := strconv.Atoi(nums[2])
+		switch {
+		case a > 31: // 2025-07-26, 2025年7月26日
+			year, mon, day = a, b, c
+		case strings.Contains(s, "/") && a <= 12: // 07/26/2025
+			mon, day, year = a, b, c
+		default: // 26.07.2025, 26/07/2025
+			day, mon, year = a, b, c
+		}
+	default:
+		return time.Time{}, false
+	}
+
+	if year < 100 {
+		year += 2000
+	}
+
+	if year < 1970 || mon < 1 || mon > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	date := time.Date(year, time.Month(mon), day, 0, 0, 0, 0, time.Local)
+
+	if clock != "" {
+		ts, ok := parseTime(clock)
+		if !ok {
+			return time.Time{}, false
+		}
+		date = withClock(date, ts)
+	}
+
+	return date, true
+}
+
+func findMonth(s string) int {
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '.' || r == ',' || (r >= '0' && r <= '9')
+	}) {
+		for i, names := range months {
+			for _, name := range names {
+				if strings.HasPrefix(word, name) {
+					return i + 1
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func withClock(date, clock time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local)
+}
+
+// parseTime supports: "6:35 AM", "6:35 p.m.", "18:35", "18:35:12"
+func parseTime(s string) (time.Time, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(".", "", " ", "").Replace(s)
+
+	for _, layout := range []string{"15:04:05", "15:04", "3:04:05pm", "3:04pm"} {
+		if ts, err := time.Parse(layout, s); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
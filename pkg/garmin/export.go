@@ -0,0 +1,148 @@
+package garmin
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+)
+
+// ReadExport reads weighings from Garmin "Export Your Data" archive
+// (DI_CONNECT/DI-Connect-Wellness/*_userBioMetrics.json)
+func ReadExport(name string) ([]*core.Weight, error) {
+	zipFile, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+
+	var weights []*core.Weight
+	var found bool
+
+	for _, file := range zipFile.File {
+		if !strings.Contains(path.Base(file.Name), "userBioMetrics") || path.Ext(file.Name) != ".json" {
+			continue
+		}
+
+		found = true
+
+		items, err := readBioMetrics(file)
+		if err != nil {
+			return nil, err
+		}
+		weights = append(weights, items...)
+	}
+
+	if !found {
+		return nil, errors.New("garmin: can't find userBioMetrics in export archive")
+	}
+
+	return weights, nil
+}
+
+func readBioMetrics(file *zip.File) ([]*core.Weight, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var items []struct {
+		Weight *struct {
+			Weight         float32    `json:"weight"`         // 63900.0
+			BMI            float32    `json:"bmi"`            // 21.6
+			BodyFat        float32    `json:"bodyFat"`        // 8.1
+			BodyWater      float32    `json:"bodyWater"`      // 68.3
+			BoneMass       float32    `json:"boneMass"`       // 3099
+			MuscleMass     float32    `json:"muscleMass"`     // 55599
+			PhysiqueRating int        `json:"physiqueRating"` // 4
+			VisceralFat    int        `json:"visceralFat"`    // 9
+			MetabolicAge   float64    `json:"metabolicAge"`   // 1104492410000
+			SourceType     string     `json:"sourceType"`     // INDEX_SCALE
+			TimestampGMT   exportTime `json:"timestampGMT"`   // 1753533337000 or 2025-07-26T12:35:37.0
+			Date           exportTime `json:"date"`
+		} `json:"weight"`
+	}
+
+	if err = json.NewDecoder(rc).Decode(&items); err != nil {
+		return nil, err
+	}
+
+	var weights []*core.Weight
+
+	for _, item := range items {
+		metric := item.Weight
+		if metric == nil || metric.Weight == 0 {
+			continue // other biometrics (height, VO2 max...)
+		}
+
+		ts := metric.TimestampGMT.Time
+		if ts.IsZero() {
+			ts = metric.Date.Time
+		}
+
+		w := &core.Weight{
+			Date:   ts,
+			Weight: grams(metric.Weight),
+
+			BMI:       metric.BMI,
+			BodyFat:   metric.BodyFat,
+			BodyWater: metric.BodyWater,
+			BoneMass:  grams(metric.BoneMass),
+
+			PhysiqueRating: metric.PhysiqueRating,
+			VisceralFat:    metric.VisceralFat,
+
+			SkeletalMuscleMass: grams(metric.MuscleMass),
+
+			Source: metric.SourceType,
+		}
+
+		// metabolic age is stored same as in API - in milliseconds
+		if metric.MetabolicAge > 1000 {
+			w.MetabolicAge = int(metric.MetabolicAge / 31536e6)
+		} else {
+			w.MetabolicAge = int(metric.MetabolicAge)
+		}
+
+		weights = append(weights, w)
+	}
+
+	return weights, nil
+}
+
+// grams - export has grams, same as API
+func grams(f float32) float32 {
+	return f / 1000
+}
+
+// exportTime - UTC time in milliseconds or string format
+type exportTime struct {
+	time.Time
+}
+
+func (t *exportTime) UnmarshalJSON(data []byte) error {
+	if ms, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		t.Time = time.UnixMilli(ms)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		return nil // null or unknown format
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05.0", time.DateTime, "2006-01-02T15:04:05"} {
+		if ts, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			t.Time = ts
+			return nil
+		}
+	}
+
+	return errors.New("garmin: unknown time format: " + s)
+}
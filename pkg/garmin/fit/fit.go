@@ -2,9 +2,12 @@ package fit
 
 import (
 	"io"
+	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+	"github.com/muktihari/fit/decoder"
 	"github.com/muktihari/fit/encoder"
+	"github.com/muktihari/fit/profile/basetype"
 	"github.com/muktihari/fit/profile/filedef"
 	"github.com/muktihari/fit/profile/mesgdef"
 	"github.com/muktihari/fit/profile/typedef"
@@ -56,9 +59,92 @@ func WriteWeight(w io.Writer, weights ...*core.Weight) error {
 		//scale.VisceralFatMass = 0
 
 		file.WeightScales = append(file.WeightScales, scale)
+
+		// local time of measurement place, if it is known
+		if weight.Zone != "" {
+			_, offset := weight.LocalDate().Zone()
+
+			correlation := mesgdef.NewTimestampCorrelation(nil)
+			correlation.Timestamp = weight.Date
+			correlation.LocalTimestamp = weight.Date.Add(time.Duration(offset) * time.Second)
+			file.UnrelatedMessages = append(file.UnrelatedMessages, correlation.ToMesg(nil))
+		}
 	}
 
 	// Convert back to FIT protocol messages
 	fit := file.ToFIT(nil)
 	return encoder.New(w).Encode(&fit)
 }
+
+// ReadWeight decodes weight_scale messages from FIT file (may be chained FIT files)
+func ReadWeight(r io.Reader) ([]*core.Weight, error) {
+	var weights []*core.Weight
+
+	dec := decoder.New(r)
+	for dec.Next() {
+		fit, err := dec.Decode()
+		if err != nil {
+			return nil, err
+		}
+
+		file := filedef.NewWeight(fit.Messages...)
+
+		// UTC offsets of local timestamps
+		offsets := map[int64]int{}
+		for i := range file.UnrelatedMessages {
+			if mesg := &file.UnrelatedMessages[i]; mesg.Num == typedef.MesgNumTimestampCorrelation {
+				correlation := mesgdef.NewTimestampCorrelation(mesg)
+				offsets[correlation.Timestamp.Unix()] = int(correlation.LocalTimestamp.Sub(correlation.Timestamp).Seconds())
+			}
+		}
+
+		for _, scale := range file.WeightScales {
+			if scale.Weight == typedef.WeightInvalid || scale.Weight == typedef.WeightCalculating {
+				continue
+			}
+
+			weight := &core.Weight{
+				Date:   scale.Timestamp,
+				Weight: float32(scale.Weight) / 100,
+			}
+
+			if offset, ok := offsets[scale.Timestamp.Unix()]; ok {
+				weight.Zone = core.OffsetZone(offset)
+			}
+
+			if scale.Bmi != basetype.Uint16Invalid {
+				weight.BMI = float32(scale.Bmi) / 10
+			}
+			if scale.PercentFat != basetype.Uint16Invalid {
+				weight.BodyFat = float32(scale.PercentFat) / 100
+			}
+			if scale.PercentHydration != basetype.Uint16Invalid {
+				weight.BodyWater = float32(scale.PercentHydration) / 100
+			}
+			if scale.BoneMass != basetype.Uint16Invalid {
+				weight.BoneMass = float32(scale.BoneMass) / 100
+			}
+
+			if scale.MetabolicAge != basetype.Uint8Invalid {
+				weight.MetabolicAge = int(scale.MetabolicAge)
+			}
+			if scale.MuscleMass != basetype.Uint16Invalid {
+				weight.SkeletalMuscleMass = float32(scale.MuscleMass) / 100
+			}
+			if scale.PhysiqueRating != basetype.Uint8Invalid {
+				weight.PhysiqueRating = int(scale.PhysiqueRating)
+			}
+			if scale.VisceralFatRating != basetype.Uint8Invalid {
+				weight.VisceralFat = int(scale.VisceralFatRating)
+			}
+
+			if scale.BasalMet != basetype.Uint16Invalid {
+				weight.BasalMetabolism = int(scale.BasalMet / 4)
+			}
+
+			weights = append(weights, weight)
+		}
+	}
+
+	return weights, nil
+}
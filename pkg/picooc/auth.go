@@ -1,6 +1,7 @@
 package picooc
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
@@ -10,12 +11,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
 	"github.com/google/uuid"
 )
 
 const api = "https://api2.picooc-int.com/v1/api/"
 
-func (c *Client) Login(username, password string) error {
+func (c *Client) Login(ctx context.Context, username, password string) error {
 	form := c.values("user_login_new")
 
 	var req1 struct {
@@ -55,8 +57,8 @@ func (c *Client) Login(username, password string) error {
 
 	form.Set("reqData", string(data))
 
-	res, err := c.client.Post(
-		api+"account/login", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
+	res, err := core.Post(
+		ctx, c.client, api+"account/login", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
 	)
 	if err != nil {
 		return err
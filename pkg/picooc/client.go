@@ -1,6 +1,7 @@
 package picooc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -25,11 +26,11 @@ func NewClient() *Client {
 	}
 }
 
-func (c *Client) GetAllWeights() ([]*core.Weight, error) {
-	return c.GetFilterWeights("")
+func (c *Client) GetAllWeights(ctx context.Context) ([]*core.Weight, error) {
+	return c.GetFilterWeights(ctx, "")
 }
 
-func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
+func (c *Client) GetFilterWeights(ctx context.Context, name string) ([]*core.Weight, error) {
 	roleID, ok := c.roleIDs[name]
 	if !ok {
 		return nil, errors.New("picooc: unknown user: " + name)
@@ -45,7 +46,7 @@ func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
 	params.Set("roleId", roleID)
 
 	for {
-		res, err := c.client.Get(api + "bodyIndex/bodyIndexList?" + params.Encode())
+		res, err := core.Get(ctx, c.client, api+"bodyIndex/bodyIndexList?"+params.Encode())
 		if err != nil {
 			return nil, err
 		}
@@ -1,6 +1,7 @@
 package tanita
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -26,8 +27,8 @@ func NewClient() *Client {
 	}
 }
 
-func (c *Client) Login(username, password string) error {
-	res, err := c.client.Get("https://mytanita.eu/en/user/login")
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	res, err := core.Get(ctx, c.client, "https://mytanita.eu/en/user/login")
 	if err != nil {
 		return err
 	}
@@ -44,8 +45,8 @@ func (c *Client) Login(username, password string) error {
 		"mail=%s&password=%s&token=%s&login=Login",
 		url.QueryEscape(username), url.QueryEscape(password), token,
 	)
-	res, err = c.client.Post(
-		"https://mytanita.eu/en/user/processlogin", "application/x-www-form-urlencoded", strings.NewReader(form),
+	res, err = core.Post(
+		ctx, c.client, "https://mytanita.eu/en/user/processlogin", "application/x-www-form-urlencoded", strings.NewReader(form),
 	)
 	if err != nil {
 		return err
@@ -65,9 +66,9 @@ func (c *Client) Login(username, password string) error {
 	return nil
 }
 
-func (c *Client) GetAllWeights() ([]*core.Weight, error) {
+func (c *Client) GetAllWeights(ctx context.Context) ([]*core.Weight, error) {
 	// VERY long operation
-	res, err := c.client.Get("https://mytanita.eu/en/user/export-csv")
+	res, err := core.Get(ctx, c.client, "https://mytanita.eu/en/user/export-csv")
 	if err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package xiaomi
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/rc4"
@@ -16,6 +17,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,18 +29,18 @@ const (
 	AppMiFitness  = "miothealth"
 )
 
-func (c *Client) Login(username, password string) error {
-	res1, err := c.serviceLogin()
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	res1, err := c.serviceLogin(ctx)
 	if err != nil {
 		return err
 	}
 
-	res2, err := c.serviceLogin2(res1, username, password)
+	res2, err := c.serviceLogin2(ctx, res1, username, password)
 	if err != nil {
 		return err
 	}
 
-	return c.serviceLogin3(res2.Location)
+	return c.serviceLogin3(ctx, res2.Location)
 }
 
 type loginResponse1 struct {
@@ -58,8 +60,8 @@ type loginResponse1 struct {
 	//Desc           string      `json:"desc"`
 }
 
-func (c *Client) serviceLogin() (*loginResponse1, error) {
-	res, err := c.client.Get("https://account.xiaomi.com/pass/serviceLogin?_json=true&sid=" + c.sid)
+func (c *Client) serviceLogin(ctx context.Context) (*loginResponse1, error) {
+	res, err := core.Get(ctx, c.client, "https://account.xiaomi.com/pass/serviceLogin?_json=true&sid="+c.sid)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +82,7 @@ func (c *Client) serviceLogin() (*loginResponse1, error) {
 type loginResponse2 struct {
 	//Qs             string      `json:"qs"`
 	Ssecurity []byte `json:"ssecurity"`
-	//Code           int         `json:"code"`
+	Code      int    `json:"code"`
 	PassToken string `json:"passToken"`
 	//Description    string      `json:"description"`
 	//SecurityStatus int         `json:"securityStatus"`
@@ -89,16 +91,29 @@ type loginResponse2 struct {
 	//CUserId        string      `json:"cUserId"`
 	//Result         string      `json:"result"`
 	//Psecurity      string      `json:"psecurity"`
-	//CaptchaUrl     interface{} `json:"captchaUrl"`
-	Location string `json:"location"`
+	CaptchaUrl      string `json:"captchaUrl"`
+	NotificationUrl string `json:"notificationUrl"`
+	Location        string `json:"location"`
 	//Pwd            int         `json:"pwd"`
 	//Child          int         `json:"child"`
-	//Desc           string      `json:"desc"`
+	Desc string `json:"desc"`
+}
+
+// Challenges - handlers for additional login steps, login fails if handler is not set
+type Challenges struct {
+	Captcha func(ctx context.Context, image []byte) (string, error)   // returns text from captcha image
+	Code    func(ctx context.Context, message string) (string, error) // returns code from SMS or email
 }
 
-func (c *Client) serviceLogin2(res1 *loginResponse1, username, password string) (*loginResponse2, error) {
+const maxChallenges = 3
+
+func (c *Client) serviceLogin2(ctx context.Context, res1 *loginResponse1, username, password string) (*loginResponse2, error) {
 	hash := fmt.Sprintf("%X", md5.Sum([]byte(password)))
 
+	if c.DeviceID == "" {
+		c.DeviceID = core.RandString(16, 62)
+	}
+
 	form := url.Values{
 		"_json":    {"true"},
 		"hash":     {hash},
@@ -109,14 +124,59 @@ func (c *Client) serviceLogin2(res1 *loginResponse1, username, password string)
 		"user":     {username},
 	}
 
-	req, err := http.NewRequest(
-		"POST", "https://account.xiaomi.com/pass/serviceLoginAuth2", strings.NewReader(form.Encode()),
+	var ick string // captcha session
+
+	for i := 0; ; i++ {
+		res2, err := c.serviceLoginAuth2(ctx, form, ick)
+		if err != nil {
+			return nil, err
+		}
+
+		if res2.CaptchaUrl != "" {
+			if i == maxChallenges {
+				return nil, errors.New("xiaomi: wrong captcha")
+			}
+			var code string
+			if code, ick, err = c.solveCaptcha(ctx, res2.CaptchaUrl); err != nil {
+				return nil, err
+			}
+			form.Set("captCode", code)
+			continue
+		}
+
+		if res2.NotificationUrl != "" {
+			// after verification login is finished with new passToken, without password
+			if res2, err = c.verifyIdentity(ctx, res2.NotificationUrl, form); err != nil {
+				return nil, err
+			}
+		}
+
+		if res2.Code != 0 || res2.Location == "" {
+			return nil, fmt.Errorf("xiaomi: login error: %d %s", res2.Code, res2.Desc)
+		}
+
+		c.passToken = res2.PassToken
+		c.ssecurity = res2.Ssecurity
+		c.userID = res2.UserId
+
+		return res2, nil
+	}
+}
+
+func (c *Client) serviceLoginAuth2(ctx context.Context, form url.Values, ick string) (*loginResponse2, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", "https://account.xiaomi.com/pass/serviceLoginAuth2", strings.NewReader(form.Encode()),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Cookie", "deviceId="+core.RandString(16, 62))
+	cookie := "deviceId=" + c.DeviceID
+	if ick != "" {
+		cookie += "; ick=" + ick
+	}
+
+	req.Header.Set("Cookie", cookie)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	res, err := c.client.Do(req)
@@ -134,20 +194,200 @@ func (c *Client) serviceLogin2(res1 *loginResponse1, username, password string)
 		return nil, err
 	}
 
-	c.passToken = res2.PassToken
-	c.ssecurity = res2.Ssecurity
-	c.userID = res2.UserId
-
 	return &res2, nil
 }
 
-func (c *Client) serviceLogin3(location string) error {
-	res, err := c.client.Get(location)
+// solveCaptcha downloads captcha image and returns answer with captcha session
+func (c *Client) solveCaptcha(ctx context.Context, captchaURL string) (code, ick string, err error) {
+	if c.Captcha == nil {
+		return "", "", errors.New("xiaomi: captcha required")
+	}
+
+	if strings.HasPrefix(captchaURL, "/") {
+		captchaURL = "https://account.xiaomi.com" + captchaURL
+	}
+
+	res, err := core.Get(ctx, c.client, captchaURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", "", core.NewStatusError("xiaomi: captcha error: ", res)
+	}
+
+	image, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == "ick" {
+			ick = cookie.Value
+		}
+	}
+
+	if code, err = c.Captcha(ctx, image); err != nil {
+		return "", "", err
+	}
+
+	return code, ick, nil
+}
+
+var accountURL = &url.URL{Scheme: "https", Host: "account.xiaomi.com", Path: "/"}
+
+// verifyIdentity sends code to phone or email, verifies it and finishes login with passToken
+func (c *Client) verifyIdentity(ctx context.Context, notificationURL string, login url.Values) (*loginResponse2, error) {
+	if c.Code == nil {
+		return nil, errors.New("xiaomi: verification required")
+	}
+
+	u, err := url.Parse(notificationURL)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Timeout: time.Minute, Jar: jar} // important for identity_session
+
+	// device becomes trusted only with same deviceId as login
+	jar.SetCookies(accountURL, []*http.Cookie{{Name: "deviceId", Value: c.DeviceID}})
+
+	// 1. get verification methods
+	res, err := core.Get(ctx, client, "https://account.xiaomi.com/identity/list?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readLoginResponse(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var res1 struct {
+		Flag int `json:"flag"` // 4 - phone, 8 - email
+	}
+	if err = json.Unmarshal(body, &res1); err != nil {
+		return nil, err
+	}
+
+	var method string
+	switch res1.Flag {
+	case 4:
+		method = "Phone"
+	case 8:
+		method = "Email"
+	default:
+		return nil, fmt.Errorf("xiaomi: unsupported verification: %d", res1.Flag)
+	}
+
+	params := url.Values{
+		"_dc":     {strconv.FormatInt(time.Now().UnixMilli(), 10)},
+		"sid":     {query.Get("sid")},
+		"context": {query.Get("context")},
+		"mask":    {"0"},
+		"_locale": {"en_US"},
+	}
+
+	// 2. send code to phone or email
+	form := url.Values{"retry": {"0"}, "icode": {""}, "_json": {"true"}}
+	if err = identityRequest(ctx, client, "send"+method+"Ticket", params, form, nil); err != nil {
+		return nil, err
+	}
+
+	code, err := c.Code(ctx, "enter Xiaomi verification code from "+strings.ToLower(method))
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. verify code
+	flag := strconv.Itoa(res1.Flag)
+	params.Set("_flag", flag)
+	form = url.Values{"_flag": {flag}, "ticket": {code}, "trust": {"true"}, "_json": {"true"}}
+
+	var res2 struct {
+		Location string `json:"location"`
+	}
+	if err = identityRequest(ctx, client, "verify"+method, params, form, &res2); err != nil {
+		return nil, err
+	}
+
+	// 4. finish verification, so device becomes trusted and passToken is set
+	res, err = core.Get(ctx, client, res2.Location)
+	if err != nil {
+		return nil, err
+	}
+	_ = res.Body.Close()
+
+	var userID, passToken string
+	for _, cookie := range jar.Cookies(accountURL) {
+		switch cookie.Name {
+		case "userId":
+			userID = cookie.Value
+		case "passToken":
+			passToken = cookie.Value
+		}
+	}
+
+	if passToken == "" {
+		return nil, errors.New("xiaomi: verification failed")
+	}
+
+	// 5. same login params as password login, so OAuth2 gets its location
+	params = url.Values{"_json": {"true"}}
+	for _, k := range []string{"sid", "callback", "qs", "_sign"} {
+		params.Set(k, login.Get(k))
+	}
+
+	return c.serviceLoginToken(ctx, params, userID, passToken)
+}
+
+func identityRequest(ctx context.Context, client *http.Client, path string, params, form url.Values, v any) error {
+	res, err := core.Post(
+		ctx, client, "https://account.xiaomi.com/identity/auth/"+path+"?"+params.Encode(),
+		"application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+
+	body, err := readLoginResponse(res)
+	if err != nil {
+		return err
+	}
+
+	var res1 struct {
+		Code int    `json:"code"`
+		Tips string `json:"tips"`
+	}
+	if err = json.Unmarshal(body, &res1); err != nil {
+		return err
+	}
+
+	if res1.Code != 0 {
+		return fmt.Errorf("xiaomi: %s error: %d %s", path, res1.Code, res1.Tips)
+	}
+
+	if v != nil {
+		return json.Unmarshal(body, v)
+	}
+
+	return nil
+}
+
+func (c *Client) serviceLogin3(ctx context.Context, location string) error {
+	res, err := core.Get(ctx, c.client, location)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
+	// new login replaces old session cookies
+	c.cookies = ""
+
 	for _, s := range res.Header["Set-Cookie"] {
 		s, _, _ = strings.Cut(s, ";")
 		if len(c.cookies) > 0 {
@@ -159,13 +399,13 @@ func (c *Client) serviceLogin3(location string) error {
 	return nil
 }
 
-func (c *Client) OAuth2(params, username, password string) (string, error) {
-	res1, err := c.oauth2Authorize(params)
+func (c *Client) OAuth2(ctx context.Context, params, username, password string) (string, error) {
+	res1, err := c.oauth2Authorize(ctx, params)
 	if err != nil {
 		return "", err
 	}
 
-	res2, err := c.serviceLogin2(res1, username, password)
+	res2, err := c.serviceLogin2(ctx, res1, username, password)
 	if err != nil {
 		return "", err
 	}
@@ -182,7 +422,7 @@ func (c *Client) OAuth2(params, username, password string) (string, error) {
 		},
 	}
 
-	res, err := client.Get(res2.Location)
+	res, err := core.Get(ctx, client, res2.Location)
 	if err != nil {
 		return "", err
 	}
@@ -194,8 +434,8 @@ func (c *Client) OAuth2(params, username, password string) (string, error) {
 	return code, nil
 }
 
-func (c *Client) oauth2Authorize(params string) (*loginResponse1, error) {
-	res, err := c.client.Get("https://account.xiaomi.com/oauth2/authorize?" + params)
+func (c *Client) oauth2Authorize(ctx context.Context, params string) (*loginResponse1, error) {
+	res, err := core.Get(ctx, c.client, "https://account.xiaomi.com/oauth2/authorize?"+params)
 	if err != nil {
 		return nil, err
 	}
@@ -215,7 +455,7 @@ func (c *Client) oauth2Authorize(params string) (*loginResponse1, error) {
 		return nil, err
 	}
 
-	res, err = c.client.Get(json1.Data.OauthLoginUrl)
+	res, err = core.Get(ctx, c.client, json1.Data.OauthLoginUrl)
 	if err != nil {
 		return nil, err
 	}
@@ -233,7 +473,7 @@ func (c *Client) oauth2Authorize(params string) (*loginResponse1, error) {
 	return &res1, nil
 }
 
-func (c *Client) Request(baseURL, apiURL, params string, headers map[string]string) ([]byte, error) {
+func (c *Client) Request(ctx context.Context, baseURL, apiURL, params string, headers map[string]string) ([]byte, error) {
 	form := url.Values{"data": {params}}
 
 	nonce := GenNonce()
@@ -257,7 +497,7 @@ func (c *Client) Request(baseURL, apiURL, params string, headers map[string]stri
 	// 4. add nonce
 	form.Set("_nonce", base64.StdEncoding.EncodeToString(nonce))
 
-	req, err := http.NewRequest("POST", baseURL+apiURL, strings.NewReader(form.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", baseURL+apiURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -276,7 +516,7 @@ func (c *Client) Request(baseURL, apiURL, params string, headers map[string]stri
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.New(res.Status)
+		return nil, core.NewStatusError("xiaomi: ", res)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -310,40 +550,65 @@ func (c *Client) Request(baseURL, apiURL, params string, headers map[string]stri
 	return res1.Result, nil
 }
 
-func (c *Client) LoginWithToken(token string) error {
-	userID, passToken, _ := strings.Cut(token, ":")
+// LoginWithToken - token format: userID:passToken[:deviceID]
+func (c *Client) LoginWithToken(ctx context.Context, token string) error {
+	fields := strings.Split(token, ":")
+	if len(fields) < 2 {
+		return errors.New("xiaomi: wrong token")
+	}
+
+	if len(fields) >= 3 {
+		c.DeviceID = fields[2]
+	}
+
+	params := url.Values{"_json": {"true"}, "sid": {c.sid}}
 
-	req, err := http.NewRequest("GET", "https://account.xiaomi.com/pass/serviceLogin?_json=true&sid="+c.sid, nil)
+	res2, err := c.serviceLoginToken(ctx, params, fields[0], fields[1])
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Cookie", fmt.Sprintf("userId=%s; passToken=%s", userID, passToken))
+	c.passToken = res2.PassToken
+	c.ssecurity = res2.Ssecurity
+	c.userID = res2.UserId
+
+	return c.serviceLogin3(ctx, res2.Location)
+}
+
+// serviceLoginToken - login with passToken instead of password
+func (c *Client) serviceLoginToken(ctx context.Context, params url.Values, userID, passToken string) (*loginResponse2, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://account.xiaomi.com/pass/serviceLogin?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := fmt.Sprintf("userId=%s; passToken=%s", userID, passToken)
+	if c.DeviceID != "" {
+		cookie += "; deviceId=" + c.DeviceID
+	}
+
+	req.Header.Set("Cookie", cookie)
 
 	res, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	body, err := readLoginResponse(res)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var res2 loginResponse2
 	if err = json.Unmarshal(body, &res2); err != nil {
-		return err
+		return nil, err
 	}
 
-	c.passToken = res2.PassToken
-	c.ssecurity = res2.Ssecurity
-	c.userID = res2.UserId
-
-	return c.serviceLogin3(res2.Location)
+	return &res2, nil
 }
 
 func (c *Client) Token() string {
-	return fmt.Sprintf("%d:%s", c.userID, c.passToken)
+	return fmt.Sprintf("%d:%s:%s", c.userID, c.passToken, c.DeviceID)
 }
 
 const loginPrefix = "&&&START&&&"
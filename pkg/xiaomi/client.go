@@ -1,11 +1,14 @@
 package xiaomi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
@@ -19,6 +22,10 @@ type Client struct {
 	userID    int64  // for some requests
 	ssecurity []byte // for encryption
 	passToken string
+
+	DeviceID string // same for all logins and saved with token, so verified device stays trusted
+
+	Challenges
 }
 
 func NewClient(app string) *Client {
@@ -28,11 +35,11 @@ func NewClient(app string) *Client {
 	}
 }
 
-func (c *Client) GetAllWeights() ([]*core.Weight, error) {
-	return c.getAllWeights("")
+func (c *Client) GetAllWeights(ctx context.Context) ([]*core.Weight, error) {
+	return c.getAllWeights(ctx, "")
 }
 
-func (c *Client) getAllWeights(region string) ([]*core.Weight, error) {
+func (c *Client) getAllWeights(ctx context.Context, region string) ([]*core.Weight, error) {
 	var weights []*core.Weight
 
 	ts := time.Now().Add(24 * time.Hour).Unix()
@@ -40,7 +47,7 @@ func (c *Client) getAllWeights(region string) ([]*core.Weight, error) {
 
 	for {
 		// this request depends on user region
-		data, err := c.Request(MiFitnessURL(region), "/app/v1/data/get_fitness_data_by_time", params, nil)
+		data, err := c.Request(ctx, MiFitnessURL(region), "/app/v1/data/get_fitness_data_by_time", params, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -68,91 +75,17 @@ func (c *Client) getAllWeights(region string) ([]*core.Weight, error) {
 				continue
 			}
 
-			var res2 struct {
-				BasalMetabolism  int     `json:"basal_metabolism"`   // S400, Eight
-				BMI              float32 `json:"bmi"`                // S400, Eight
-				BodyAge          int     `json:"body_age"`           // S400, Eight
-				BodyFatRate      float32 `json:"body_fat_rate"`      // S400, Eight
-				BodyMoistureMass float32 `json:"body_moisture_mass"` // S400, Eight
-				BodyScore        int     `json:"body_score"`         // S400, Eight
-				BoneMass         float32 `json:"bone_mass"`          // S400, Eight
-				BoneRate         float32 `json:"bone_rate"`          // S400, Eight
-				BPM              int     `json:"bpm"`                // S400, Eight
-				FatControl       float32 `json:"fat_control"`        // S400, Eight
-				MoistureRate     float32 `json:"moisture_rate"`      // S400, Eight
-				MuscleControl    float32 `json:"muscle_control"`     // S400, Eight
-				MuscleMass       float32 `json:"muscle_mass"`        // S400, Eight
-				MuscleRate       float32 `json:"muscle_rate"`        // S400, Eight
-				ProteinMass      float32 `json:"protein_mass"`       // S400, Eight
-				ProteinRate      float32 `json:"protein_rate"`       // S400, Eight
-				Somatotype       int     `json:"somatotype"`         // S400, Eight
-				StandardWeight   int     `json:"standard_weight"`    // S400, Eight
-				StandardWeightV2 float32 `json:"standard_weight_v2"` // S400, Eight
-				Time             int     `json:"time"`               // S400, Eight
-				VisceralFat      float32 `json:"visceral_fat"`       // S400, Eight
-				Weight           float32 `json:"weight"`             // S400, Eight
-				WeightControl    float32 `json:"weight_control"`     // S400, Eight
-				Whr              float32 `json:"whr"`                // S400, Eight
-
-				//FatFreeBody        float32 `json:"fat_free_body"`        // S400
-				//ScoreStandardType  int     `json:"score_standard_type"`  // S400
-				SkeletalMuscleMass float32 `json:"skeletal_muscle_mass"` // S400
-
-				//BodyShape                 int     `json:"body_shape"`                   // Eight
-				//FatMass                   float32 `json:"fat_mass"`                     // Eight
-				//LeftLowerLimbFatMass      float32 `json:"left_lower_limb_fat_mass"`     // Eight
-				//LeftLowerLimbFatRank      int     `json:"left_lower_limb_fat_rank"`     // Eight
-				//LeftLowerLimbMuscleMass   int     `json:"left_lower_limb_muscle_mass"`  // Eight
-				//LeftLowerLimbMuscleRank   int     `json:"left_lower_limb_muscle_rank"`  // Eight
-				//LeftUpperLimbFatMass      float32 `json:"left_upper_limb_fat_mass"`     // Eight
-				//LeftUpperLimbFatRank      int     `json:"left_upper_limb_fat_rank"`     // Eight
-				//LeftUpperLimbMuscleMass   float32 `json:"left_upper_limb_muscle_mass"`  // Eight
-				//LeftUpperLimbMuscleRank   int     `json:"left_upper_limb_muscle_rank"`  // Eight
-				//LimbsFatBalance           int     `json:"limbs_fat_balance"`            // Eight
-				//LimbsMuscleBalance        int     `json:"limbs_muscle_balance"`         // Eight
-				//LimbsSkeletalMuscleIndex  float32 `json:"limbs_skeletal_muscle_index"`  // Eight
-				//LowerLimbFatBalance       int     `json:"lower_limb_fat_balance"`       // Eight
-				//LowerLimbMuscleBalance    int     `json:"lower_limb_muscle_balance"`    // Eight
-				//RecommendedCaloriesIntake int     `json:"recommended_calories_intake"`  // Eight
-				//RightLowerLimbFatMass     float32 `json:"right_lower_limb_fat_mass"`    // Eight
-				//RightLowerLimbFatRank     int     `json:"right_lower_limb_fat_rank"`    // Eight
-				//RightLowerLimbMuscleMass  float32 `json:"right_lower_limb_muscle_mass"` // Eight
-				//RightLowerLimbMuscleRank  int     `json:"right_lower_limb_muscle_rank"` // Eight
-				//RightUpperLimbFatMass     float32 `json:"right_upper_limb_fat_mass"`    // Eight
-				//RightUpperLimbFatRank     int     `json:"right_upper_limb_fat_rank"`    // Eight
-				//RightUpperLimbMuscleMass  float32 `json:"right_upper_limb_muscle_mass"` // Eight
-				//RightUpperLimbMuscleRank  int     `json:"right_upper_limb_muscle_rank"` // Eight
-				//TrunkFatMass              float32 `json:"trunk_fat_mass"`               // Eight
-				//TrunkFatRank              int     `json:"trunk_fat_rank"`               // Eight
-				//TrunkMuscleMass           float32 `json:"trunk_muscle_mass"`            // Eight
-				//TrunkMuscleRank           int     `json:"trunk_muscle_rank"`            // Eight
-				//UpperLimbFatBalance       int     `json:"upper_limb_fat_balance"`       // Eight
-				//UpperLimbMuscleBalance    int     `json:"upper_limb_muscle_balance"`    // Eight
-			}
-
-			if err = json.Unmarshal([]byte(v1.Value), &res2); err != nil {
+			w, err := decodeFitnessWeight(v1.Value)
+			if err != nil {
 				return nil, err
 			}
 
-			w := &core.Weight{
-				Date:      time.Unix(int64(res2.Time), 0), // 1732550224
-				Weight:    res2.Weight,                    // 69.8
-				BMI:       res2.BMI,                       // 23.6
-				BodyFat:   res2.BodyFatRate,               // 19.6
-				BodyWater: res2.MoistureRate,              // 51
-				BoneMass:  res2.BoneMass,                  // 2.8
-
-				MetabolicAge: res2.BodyAge,    // 36
-				MuscleMass:   res2.MuscleMass, // 53.3
-				ProteinMass:  res2.ProteinMass,
-				VisceralFat:  int(res2.VisceralFat),
-
-				BasalMetabolism:    res2.BasalMetabolism,
-				BodyScore:          res2.BodyScore,
-				HeartRate:          res2.BPM,
-				SkeletalMuscleMass: res2.SkeletalMuscleMass,
-
-				Source: v1.Sid, // blt.3.xxx
+			w.Source = v1.Sid // blt.3.xxx
+
+			if v1.ZoneName != "" {
+				w.Zone = v1.ZoneName
+			} else if v1.ZoneOffset != 0 {
+				w.Zone = core.OffsetZone(v1.ZoneOffset)
 			}
 
 			weights = append(weights, w)
@@ -168,65 +101,235 @@ func (c *Client) getAllWeights(region string) ([]*core.Weight, error) {
 	return weights, nil
 }
 
-//func (c *Client) GetFamilyMembers() (map[int64]string, error) {
-//	params := `{"eco_api":"eco/scale/account/list"}`
-//	data, err := c.Request(MiFitnessURL(""), "/app/v1/eco/api_proxy", params, nil)
-//	if err != nil {
-//		return nil, err
-//	}
-//
-//	if data, err = readProxyResponse(data); err != nil {
-//		return nil, err
-//	}
-//
-//	var items []struct {
-//		Uid              string `json:"uid"`
-//		AccountId        string `json:"accountId"`
-//		Name             string `json:"name"`
-//		Icon             string `json:"icon"`
-//		Type             int    `json:"type"`
-//		Sex              string `json:"sex"`
-//		Height           string `json:"height"`
-//		WeightTarget     string `json:"weightTarget"`
-//		Birth            string `json:"birth"`
-//		CreationTime     int64  `json:"creationTime"`
-//		AccountCode      int    `json:"accountCode"`
-//		DeviceId         string `json:"deviceId"`
-//		WeightUpdateTime int64  `json:"weightUpdateTime"`
-//	}
-//
-//	if err = json.Unmarshal(data, &items); err != nil {
-//		return nil, err
-//	}
-//
-//	accounts := make(map[int64]string, len(items))
-//
-//	for _, v := range items {
-//		i, _ := strconv.ParseInt(v.AccountId, 10, 64)
-//		accounts[i] = v.Name
-//	}
-//
-//	return accounts, nil
-//}
+// fitnessData - record of Mi Fitness data, value is JSON string
+type fitnessData struct {
+	Sid        string `json:"sid"`
+	Key        string `json:"key"`
+	Time       int64  `json:"time"`
+	Value      string `json:"value,omitempty"`
+	ZoneOffset int    `json:"zone_offset,omitempty"` // seconds
+	ZoneName   string `json:"zone_name,omitempty"`
+}
+
+// fitnessWeight - value of Mi Fitness weight record, same names as in S400 data
+type fitnessWeight struct {
+	Time               int64   `json:"time"`
+	Weight             float32 `json:"weight"`
+	BMI                float32 `json:"bmi,omitempty"`
+	BodyFatRate        float32 `json:"body_fat_rate,omitempty"`
+	MoistureRate       float32 `json:"moisture_rate,omitempty"`
+	BoneMass           float32 `json:"bone_mass,omitempty"`
+	BodyAge            int     `json:"body_age,omitempty"`
+	MuscleMass         float32 `json:"muscle_mass,omitempty"`
+	ProteinMass        float32 `json:"protein_mass,omitempty"`
+	VisceralFat        float32 `json:"visceral_fat,omitempty"`
+	BasalMetabolism    int     `json:"basal_metabolism,omitempty"`
+	BodyScore          int     `json:"body_score,omitempty"`
+	BPM                int     `json:"bpm,omitempty"`
+	SkeletalMuscleMass float32 `json:"skeletal_muscle_mass,omitempty"`
+}
+
+const uploadSid = "scaleconnect"
+
+const uploadChunk = 100
+
+// AddWeights uploads weighings to Mi Fitness data of the main user
+func (c *Client) AddWeights(ctx context.Context, weights []*core.Weight, opts core.Options) error {
+	baseURL := MiFitnessURL(opts.Region)
+	if baseURL == "" {
+		return errors.New("xiaomi: unsupported region: " + opts.Region)
+	}
+
+	for chunk := range slices.Chunk(weights, uploadChunk) {
+		items := make([]*fitnessData, 0, len(chunk))
+
+		for _, w := range chunk {
+			value, err := json.Marshal(&fitnessWeight{
+				Time:               w.Date.Unix(),
+				Weight:             w.Weight,
+				BMI:                w.BMI,
+				BodyFatRate:        w.BodyFat,
+				MoistureRate:       w.BodyWater,
+				BoneMass:           w.BoneMass,
+				BodyAge:            w.MetabolicAge,
+				MuscleMass:         w.MuscleMass,
+				ProteinMass:        w.ProteinMass,
+				VisceralFat:        float32(w.VisceralFat),
+				BasalMetabolism:    w.BasalMetabolism,
+				BodyScore:          w.BodyScore,
+				BPM:                w.HeartRate,
+				SkeletalMuscleMass: w.SkeletalMuscleMass,
+			})
+			if err != nil {
+				return err
+			}
+
+			_, offset := w.LocalDate().Zone()
+
+			item := &fitnessData{
+				Sid:        uploadSid,
+				Key:        "weight",
+				Time:       w.Date.Unix(),
+				Value:      string(value),
+				ZoneOffset: offset,
+			}
+			if strings.Contains(w.Zone, "/") {
+				item.ZoneName = w.Zone // IANA name
+			}
+			items = append(items, item)
+		}
+
+		if err := c.fitnessRequest(ctx, baseURL, "/app/v1/data/upload_fitness_data", items); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) DeleteWeight(ctx context.Context, weight *core.Weight, opts core.Options) error {
+	baseURL := MiFitnessURL(opts.Region)
+	if baseURL == "" {
+		return errors.New("xiaomi: unsupported region: " + opts.Region)
+	}
+
+	// Source of Mi Fitness weighing is sid of the record
+	items := []*fitnessData{{Sid: weight.Source, Key: "weight", Time: weight.Date.Unix()}}
+	return c.fitnessRequest(ctx, baseURL, "/app/v1/data/delete_fitness_data", items)
+}
+
+func (c *Client) fitnessRequest(ctx context.Context, baseURL, apiURL string, items []*fitnessData) error {
+	params, err := json.Marshal(map[string]any{"data_list": items})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Request(ctx, baseURL, apiURL, string(params), nil)
+	return err
+}
+
+// Equal compares only values that Mi Fitness can store
+func (c *Client) Equal(w1, w2 *core.Weight) bool {
+	return w1.Weight == w2.Weight &&
+		w1.BMI == w2.BMI &&
+		w1.BodyFat == w2.BodyFat &&
+		w1.BodyWater == w2.BodyWater &&
+		w1.BoneMass == w2.BoneMass &&
+		w1.MetabolicAge == w2.MetabolicAge &&
+		w1.MuscleMass == w2.MuscleMass &&
+		w1.ProteinMass == w2.ProteinMass &&
+		w1.VisceralFat == w2.VisceralFat &&
+		w1.BasalMetabolism == w2.BasalMetabolism &&
+		w1.BodyScore == w2.BodyScore &&
+		w1.HeartRate == w2.HeartRate &&
+		w1.SkeletalMuscleMass == w2.SkeletalMuscleMass
+}
+
+// GetFamilyMembers returns names of Mi Fitness scale users (family members) by account ID
+func (c *Client) GetFamilyMembers(ctx context.Context) (map[int64]string, error) {
+	params := `{"eco_api":"eco/scale/account/list"}`
+	data, err := c.Request(ctx, MiFitnessURL(""), "/app/v1/eco/api_proxy", params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = readProxyResponse(data); err != nil {
+		return nil, err
+	}
+
+	return unmarshalMembers(data)
+}
+
+// GetHomeMembers returns names of Xiaomi Home scale users (family members) by account ID
+func (c *Client) GetHomeMembers(ctx context.Context, region, model string) (map[int64]string, error) {
+	var apiURL, params string
+
+	switch region {
+	case "", "cn":
+		apiURL = "/eco/scale/account/list"
+		params = fmt.Sprintf(`{"model":"%s","uid":%d}`, model, c.userID)
+	case "de", "i2", "ru", "sg", "us":
+		apiURL = "/eco/common/scale/account/list"
+		params = fmt.Sprintf(`{"model":"%s","uid":"%d"}`, model, c.userID)
+	default:
+		return nil, errors.New("xiaomi: unsupported region: " + region)
+	}
+
+	data, err := c.Request(
+		ctx, XiaomiHomeURL(region), apiURL, params,
+		map[string]string{
+			"MIOT-REQUEST-MODEL": model,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalMembers(data)
+}
+
+func unmarshalMembers(data []byte) (map[int64]string, error) {
+	var items []struct {
+		//Uid              string `json:"uid"`
+		AccountId string `json:"accountId"`
+		Name      string `json:"name"`
+		//Icon             string `json:"icon"`
+		//Type             int    `json:"type"`
+		//Sex              string `json:"sex"`
+		//Height           string `json:"height"`
+		//WeightTarget     string `json:"weightTarget"`
+		//Birth            string `json:"birth"`
+		//CreationTime     int64  `json:"creationTime"`
+		//AccountCode      int    `json:"accountCode"`
+		//DeviceId         string `json:"deviceId"`
+		//WeightUpdateTime int64  `json:"weightUpdateTime"`
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, err
+		}
+	}
+
+	accounts := make(map[int64]string, len(items))
+
+	for _, v := range items {
+		i, _ := strconv.ParseInt(v.AccountId, 10, 64)
+		accounts[i] = v.Name
+	}
+
+	return accounts, nil
+}
 
 // GetFilterWeights filter can be region or scale model
-func (c *Client) GetFilterWeights(filter string) ([]*core.Weight, error) {
+func (c *Client) GetFilterWeights(ctx context.Context, filter string) ([]*core.Weight, error) {
 	// check if the filter is a region
 	if s := MiFitnessURL(filter); s != "" {
-		return c.getAllWeights(filter)
+		return c.getAllWeights(ctx, filter)
 	}
 
-	var weights []*core.Weight
+	return c.GetScaleWeights(ctx, filter, "")
+}
+
+// GetScaleWeights returns weighings of all users from scales model, or only for one user if name is set
+func (c *Client) GetScaleWeights(ctx context.Context, model, user string) ([]*core.Weight, error) {
+	// some scales (8-electrode) don't have user names in data
+	names, err := c.GetFamilyMembers(ctx)
+	if err != nil {
+		warn(fmt.Errorf("xiaomi: can't get family members: %w", err))
+	}
+
+	d := newScaleData(names)
 
 	for ts := time.Now().UnixMilli(); ts > 0; {
 		// model is important, did may be zero
 		params := fmt.Sprintf(
 			`{"param":{"endTime":1,"beginTime":%d},"model":"%s","uid":%d,"did":0}`,
-			ts, filter, c.userID,
+			ts, model, c.userID,
 		)
 		params = fmt.Sprintf(`{"eco_api":"eco/scale/getData","params":%q}`, params)
 		// this request works only for main (CN) region
-		data, err := c.Request(MiFitnessURL(""), "/app/v1/eco/api_proxy", params, nil)
+		data, err := c.Request(ctx, MiFitnessURL(""), "/app/v1/eco/api_proxy", params, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -235,16 +338,57 @@ func (c *Client) GetFilterWeights(filter string) ([]*core.Weight, error) {
 			return nil, err
 		}
 
-		if ts, err = unmarshalScaleData(data, &weights); err != nil {
+		if ts, err = d.unmarshal(data); err != nil {
 			return nil, err
 		}
 	}
 
-	return weights, nil
+	return d.userWeights(user), nil
 }
 
-func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
-	var weights []*core.Weight
+type Device struct {
+	DID    string `json:"did"`
+	Name   string `json:"name"`
+	Model  string `json:"model"`
+	Region string `json:"region"`
+}
+
+// GetDevices returns Xiaomi Home devices from one region
+func (c *Client) GetDevices(ctx context.Context, region string) ([]*Device, error) {
+	baseURL := XiaomiHomeURL(region)
+	if baseURL == "" {
+		return nil, errors.New("xiaomi: unsupported region: " + region)
+	}
+
+	params := `{"getVirtualModel":false,"getHuamiDevices":0}`
+	data, err := c.Request(ctx, baseURL, "/home/device_list", params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var res1 struct {
+		List []*Device `json:"list"`
+	}
+	if err = json.Unmarshal(data, &res1); err != nil {
+		return nil, err
+	}
+
+	for _, device := range res1.List {
+		device.Region = region
+	}
+
+	return res1.List, nil
+}
+
+// GetModelWeights returns weighings of all users from scales model, or only for one user if name is set
+func (c *Client) GetModelWeights(ctx context.Context, region, model, user string) ([]*core.Weight, error) {
+	// some scales (8-electrode) don't have user names in data
+	names, err := c.GetHomeMembers(ctx, region, model)
+	if err != nil {
+		warn(fmt.Errorf("xiaomi: can't get family members: %w", err))
+	}
+
+	d := newScaleData(names)
 
 	switch region {
 	case "", "cn":
@@ -256,7 +400,7 @@ func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
 			)
 			// this request works only for main (CN) region
 			data, err := c.Request(
-				"https://api.io.mi.com/app", "/eco/scale/getData", params,
+				ctx, "https://api.io.mi.com/app", "/eco/scale/getData", params,
 				map[string]string{
 					"MIOT-REQUEST-MODEL": model,
 				},
@@ -265,7 +409,7 @@ func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
 				return nil, err
 			}
 
-			if ts, err = unmarshalScaleData(data, &weights); err != nil {
+			if ts, err = d.unmarshal(data); err != nil {
 				return nil, err
 			}
 		}
@@ -278,7 +422,7 @@ func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
 			)
 			// this request works only for main (CN) region
 			data, err := c.Request(
-				"https://"+region+".api.io.mi.com/app", "/eco/common/scale/getUserDataByPage", params,
+				ctx, "https://"+region+".api.io.mi.com/app", "/eco/common/scale/getUserDataByPage", params,
 				map[string]string{
 					"MIOT-REQUEST-MODEL": model,
 				},
@@ -287,7 +431,7 @@ func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
 				return nil, err
 			}
 
-			if ts, err = unmarshalScaleData(data, &weights); err != nil {
+			if ts, err = d.unmarshal(data); err != nil {
 				return nil, err
 			}
 		}
@@ -295,266 +439,77 @@ func (c *Client) GetModelWeights(region, model string) ([]*core.Weight, error) {
 		return nil, errors.New("xiaomi: unsupported region: " + region)
 	}
 
-	return weights, nil
+	return d.userWeights(user), nil
 }
 
-func unmarshalScaleData(data []byte, weights *[]*core.Weight) (ts int64, err error) {
-	var items []struct {
-		Model       string `json:"model"`
-		Uid         int64  `json:"uid"`
-		AccountId   int64  `json:"accountId"`
-		Did         string `json:"did"`
-		CreateTime  int64  `json:"createTime"`
-		Data        string `json:"data"`
-		DataVersion int    `json:"dataVersion"`
-		Sn          string `json:"sn"`
-		FromSource  int    `json:"fromSource"`
-	}
+// scaleData - weighings from scales with family member account IDs
+type scaleData struct {
+	weights  []*core.Weight
+	accounts []int64
+	names    map[int64]string
+}
 
-	if err = json.Unmarshal(data, &items); err != nil {
-		return
+// newScaleData uses names of family members, if lookup fails names are learned from data
+func newScaleData(names map[int64]string) *scaleData {
+	if names == nil {
+		names = map[int64]string{}
 	}
+	return &scaleData{names: names}
+}
 
-	for _, v1 := range items {
-		switch v1.FromSource {
-		case 1:
-			var v2 struct {
-				Weight    float32 `json:"weight"` // 87.8 kg
-				BMI       float32 `json:"bmi"`    // 25.7 points
-				BodyFat   float32 `json:"bfp"`    // 22.9 %
-				BodyWater float32 `json:"bwp"`    // 58.8 %
-				BoneMass  float32 `json:"bmc"`    // 3.7 kg
-
-				MetabolicAge int     `json:"ma"`  // 55 years
-				MuscleMass   float32 `json:"slm"` // 63.9 kg
-				BodyType     int     `json:"bt"`  // 4
-				ProteinMass  float32 `json:"pm"`  // 11.6 kg
-				VisceralFat  int     `json:"vfl"` // 9 points
-
-				BMR                int     `json:"bmr"`        // 1832 kcal
-				BodyScore          int     `json:"sbc"`        // 80 points
-				HeartRate          int     `json:"heartRate"`  // 73 bpm
-				SkeletalMuscleMass float32 `json:"smm"`        // 37.6 kg
-				ReportFrom         string  `json:"reportFrom"` // Regular
-
-				//UserID             int     `json:"miid"`       // 1234567890
-				//Duid               int     `json:"duid"`       // 6 ?
-				//UserType           int     `json:"userType"`   // 1 ?
-				//Status             int     `json:"status"`     // 0 ?
-				//Time               int64   `json:"time"`       // 1755927448
-				//ProteinPercent     float32 `json:"pp"`         // 13.2 %
-				//IdealWeight        float32 `json:"swt"`        // 73.5 kg
-				//MuscleCorrection   float32 `json:"mc"`         // -5.2
-				//WeightCorrection   float32 `json:"wc"`         // -14.3
-				//FatCorrection      float32 `json:"fc"`         // -9.1
-				//WHR                float32 `json:"whr"`        // 1.3
-				//MusclePercent      float32 `json:"slp"`        // 72.9 %
-				//BoneMassPercentage float32 `json:"bmcp"`       // 4.2 %
-				//FatMass            float32 `json:"bfm"`        // 20.1 kg
-				//LeanBodyMass       float32 `json:"ffm"`        // 67.6 kg
-				//BodyWaterMass      float32 `json:"bwm"`        // 51.5 kg
-				//BodyRes            float32 `json:"bodyRes"`    // 384.1
-				//BodyRes2           float32 `json:"bodyRes2"`   // 357.5
-				//Idx                int     `json:"idx"`        // -1
-				User struct {
-					//Uid              string `json:"uid"`
-					//Sex              string `json:"sex"`
-					//Birth            int64  `json:"birth"`
-					//AccountId        string `json:"accountId"`
-					//Icon             string `json:"icon"`
-					Name   string `json:"name"`
-					Height any    `json:"height"`
-					//Type             int    `json:"type"`
-					//AccountCode      int    `json:"accountCode"`
-					//CreationTime     int64  `json:"creationTime"`
-					//WeightTarget     string `json:"weightTarget"`
-					//WeightUpdateTime int64  `json:"weightUpdateTime"`
-					//UpdateTime       int64  `json:"updateTime"`
-				} `json:"user"`
-			}
-
-			if err = json.Unmarshal([]byte(v1.Data), &v2); err != nil {
-				return
-			}
-
-			// v2.Time has bugs. For "UserEditor" it has seconds, for Claimed it has milliseconds
-			w := &core.Weight{
-				Date:      time.UnixMilli(v1.CreateTime),
-				Weight:    v2.Weight,
-				BMI:       v2.BMI,
-				BodyFat:   v2.BodyFat,
-				BodyWater: v2.BodyWater,
-				BoneMass:  v2.BoneMass,
-
-				MetabolicAge:   v2.MetabolicAge,
-				MuscleMass:     v2.MuscleMass,
-				PhysiqueRating: v2.BodyType,
-				ProteinMass:    v2.ProteinMass,
-				VisceralFat:    v2.VisceralFat,
-
-				BasalMetabolism:    v2.BMR,
-				BodyScore:          v2.BodyScore,
-				HeartRate:          v2.HeartRate,
-				Height:             parseAnyFloat(v2.User.Height),
-				SkeletalMuscleMass: v2.SkeletalMuscleMass,
-
-				User:   v2.User.Name,
-				Source: v2.ReportFrom,
-			}
-			*weights = append(*weights, w)
-
-		case 2:
-			var v2 struct {
-				Weight    any `json:"weight"`
-				BMI       any `json:"bmi"`
-				BodyFat   any `json:"bfp"`
-				BodyWater any `json:"bwp"`
-				BoneMass  any `json:"bmc"`
-
-				MetabolicAge any `json:"ma"`
-				MuscleMass   any `json:"slm"`
-				BodyType     any `json:"bt"`
-				ProteinMass  any `json:"pm"`
-				VisceralFat  any `json:"vfl"`
-
-				BMR                any `json:"bmr"`
-				BodyScore          any `json:"sbc"`
-				HeartRate          any `json:"heartRate"`
-				SkeletalMuscleMass any `json:"smm"`
-
-				User struct {
-					Name     string `json:"name"`
-					Height   any    `json:"height"`
-					DeviceID string `json:"deviceId"`
-				} `json:"user"`
-			}
-
-			if err = json.Unmarshal([]byte(v1.Data), &v2); err != nil {
-				return
-			}
+func (d *scaleData) append(w *core.Weight, accountID int64) {
+	// remember names from data for weighings without names
+	if w.User != "" {
+		if _, ok := d.names[accountID]; !ok {
+			d.names[accountID] = w.User
+		}
+	}
+	d.weights = append(d.weights, w)
+	d.accounts = append(d.accounts, accountID)
+}
 
-			// v2.Time has bugs. For "UserEditor" it has seconds, for Claimed it has milliseconds
-			w := &core.Weight{
-				Date:      time.UnixMilli(v1.CreateTime),
-				Weight:    parseAnyFloat(v2.Weight),
-				BMI:       parseAnyFloat(v2.BMI),
-				BodyFat:   parseAnyFloat(v2.BodyFat),
-				BodyWater: parseAnyFloat(v2.BodyWater),
-				BoneMass:  parseAnyFloat(v2.BoneMass),
-
-				MetabolicAge:   parseAnyInt(v2.MetabolicAge),
-				MuscleMass:     parseAnyFloat(v2.MuscleMass),
-				PhysiqueRating: parseAnyInt(v2.BodyType),
-				ProteinMass:    parseAnyFloat(v2.ProteinMass),
-				VisceralFat:    parseAnyInt(v2.VisceralFat),
-
-				BasalMetabolism:    parseAnyInt(v2.BMR),
-				BodyScore:          parseAnyInt(v2.BodyScore),
-				HeartRate:          parseAnyInt(v2.HeartRate),
-				Height:             parseAnyFloat(v2.User.Height),
-				SkeletalMuscleMass: parseAnyFloat(v2.SkeletalMuscleMass),
-
-				User:   v2.User.Name,
-				Source: v2.User.DeviceID,
-			}
-			*weights = append(*weights, w)
-
-		case 3:
-			var v2 struct {
-				BMI         string `json:"bmi"`
-				BodyRes     string `json:"bodyRes"`
-				BodyRes2    string `json:"bodyRes2"`
-				BodyResData string `json:"bodyResData"`
-				HeartRate   int    `json:"heartRate"`
-				Mid         string `json:"mid"`
-				Time        string `json:"time"`
-				User        struct {
-					//AccountId        int64   `json:"accountId"`
-					//Birth            int64   `json:"birth"`
-					//CreateTime       int64   `json:"createTime"`
-					//Height           int     `json:"height"`
-					//Icon             string  `json:"icon"`
-					//Id               int     `json:"id"`
-					Name string `json:"name"`
-					//Sex              int     `json:"sex"`
-					//Type             int     `json:"type"`
-					//UserId           int64   `json:"userId"`
-					//WeightTarget     float32 `json:"weightTarget"`
-					//WeightUpdateTime int     `json:"weightUpdateTime"`
-				} `json:"user"`
-				Weight string `json:"weight"`
-			}
+// userWeights fills empty user names and filters weighings by user name
+func (d *scaleData) userWeights(user string) []*core.Weight {
+	weights := make([]*core.Weight, 0, len(d.weights))
+	for i, w := range d.weights {
+		if w.User == "" {
+			w.User = d.names[d.accounts[i]]
+		}
+		if user == "" || w.User == user {
+			weights = append(weights, w)
+		}
+	}
+	return weights
+}
 
-			if err = json.Unmarshal([]byte(v1.Data), &v2); err != nil {
-				return
-			}
+func (d *scaleData) unmarshal(data []byte) (ts int64, err error) {
+	var items []json.RawMessage
+	if err = json.Unmarshal(data, &items); err != nil {
+		return
+	}
 
-			w := &core.Weight{
-				Date:      time.UnixMilli(parseInt64(v2.Time)),
-				Weight:    parseFloat(v2.Weight),
-				BMI:       parseFloat(v2.BMI),
-				HeartRate: v2.HeartRate,
-				User:      v2.User.Name,
-				Source:    v1.Did,
-			}
+	for i, item := range items {
+		record := &ScaleRecord{}
+		if err = json.Unmarshal(item, record); err != nil {
+			return
+		}
 
-			if v2.BodyResData != "" {
-				var v3 struct {
-					BodyFatRate        string `json:"bfp"`  // 12.1
-					MuscleMass         string `json:"slm"`  // 32.2
-					MoistureRate       string `json:"bwp"`  // 52.1
-					BoneMass           string `json:"bmc"`  // 1.6
-					VisceralFat        string `json:"vfl"`  // 5
-					ProteinRate        string `json:"pp"`   // 31
-					SkeletalMuscleMass string `json:"smm"`  // 15.39
-					BMI                string `json:"bmi"`  // 19.1
-					StandardWeightV2   string `json:"swt"`  // 46.2
-					MuscleControl      string `json:"mc"`   // 3.5
-					WeightControl      string `json:"wc"`   // 5.3
-					FatControl         string `json:"fc"`   // 2
-					WHR                string `json:"whr"`  // 1
-					Wl                 string `json:"wl"`   // 68.2
-					Hl                 string `json:"hl"`   // 70
-					BasalMetabolic     string `json:"bmr"`  // 1143
-					Bt                 string `json:"bt"`   // 1
-					BodyAge            string `json:"ma"`   // 14
-					BodyScore          string `json:"sbc"`  // 86
-					MuscleRate         string `json:"slp"`  // 84
-					BoneRate           string `json:"bmcp"` // 3.9
-					FatMass            string `json:"bfm"`  // 4.9
-					FatFreeBody        string `json:"ffm"`  // 35.8
-					BodyMoistureMass   string `json:"bwm"`  // 21.2
-					ProteinMass        string `json:"pm"`   // 12.6
-					Smi                string `json:"smi"`  // 7.2
-				}
-
-				if err = json.Unmarshal([]byte(v2.BodyResData), &v3); err != nil {
-					return
-				}
-
-				w.BodyFat = parseFloat(v3.BodyFatRate)
-				w.BodyWater = parseFloat(v3.MoistureRate)
-				w.BoneMass = parseFloat(v3.BoneMass)
-
-				w.MetabolicAge = parseInt(v3.BodyAge)
-				w.MuscleMass = parseFloat(v3.MuscleMass)
-				w.ProteinMass = parseFloat(v3.ProteinMass)
-				w.VisceralFat = parseInt(v3.VisceralFat)
-
-				w.BasalMetabolism = parseInt(v3.BasalMetabolic)
-				w.BodyScore = parseInt(v3.BodyScore)
-				w.SkeletalMuscleMass = parseFloat(v3.SkeletalMuscleMass)
-			}
+		// last record of the page is start for the next page
+		if i == 19 {
+			ts = record.CreateTime
+		}
 
-			*weights = append(*weights, w)
+		w, err := decodeScale(record, item)
+		if err != nil {
+			return 0, err
 		}
-	}
 
-	if len(items) < 20 {
-		return 0, nil
+		if w != nil {
+			d.append(w, record.AccountId)
+		}
 	}
 
-	return items[19].CreateTime, nil
+	return ts, nil
 }
 
 func MiFitnessURL(region string) string {
@@ -567,15 +522,27 @@ func MiFitnessURL(region string) string {
 	return ""
 }
 
-//func XiaomiHomeURL(region string) string {
-//	switch region {
-//	case "", "cn":
-//		return "https://api.io.mi.com/app"
-//	case "de", "i2", "ru", "sg", "us":
-//		return "https://" + region + ".api.io.mi.com/app"
-//	}
-//	return ""
-//}
+func XiaomiHomeURL(region string) string {
+	switch region {
+	case "", "cn":
+		return "https://api.io.mi.com/app"
+	case "de", "i2", "ru", "sg", "us":
+		return "https://" + region + ".api.io.mi.com/app"
+	}
+	return ""
+}
+
+// Warn is called with non-fatal errors, when data can be loaded without some details
+var Warn func(err error)
+
+func warn(err error) {
+	if Warn != nil {
+		Warn(err)
+	}
+}
+
+// Regions - all supported Xiaomi cloud regions
+var Regions = []string{"cn", "de", "i2", "ru", "sg", "us"}
 
 func readProxyResponse(data []byte) ([]byte, error) {
 	var res1 struct {
@@ -618,6 +585,8 @@ func parseAnyInt(v any) int {
 		return parseInt(v.(string))
 	case int:
 		return v.(int)
+	case float64:
+		return int(v.(float64))
 	}
 	return 0
 }
@@ -0,0 +1,463 @@
+package xiaomi
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+)
+
+// ScaleRecord - raw record from scales data API
+type ScaleRecord struct {
+	Model       string `json:"model"`
+	Uid         int64  `json:"uid"`
+	AccountId   int64  `json:"accountId"`
+	Did         string `json:"did"`
+	CreateTime  int64  `json:"createTime"`
+	Data        string `json:"data"`
+	DataVersion int    `json:"dataVersion"`
+	Sn          string `json:"sn"`
+	FromSource  int    `json:"fromSource"`
+}
+
+// ScaleDecoder converts record to weighing, nil weighing means unsupported data
+type ScaleDecoder func(record *ScaleRecord) (*core.Weight, error)
+
+// scaleDecoders - decoders by model, model with * at the end is a prefix
+var scaleDecoders = map[string]ScaleDecoder{
+	"yunmai.scales.ms103":  decodeS400,           // Mi Body Composition Scale S400 CN
+	"yunmai.scales.ms104":  decodeS400,           // Mi Body Composition Scale S400 EU
+	"yunmai.scales.ms3001": decodeEightElectrode, // Xiaomi 8-Electrode Body Composition Scale CN
+	"xiaomi.scales.*":      decodeAnyScale,
+}
+
+// UnknownScale is called with raw record for unknown model or unsupported data.
+// Unknown models are decoded by data source.
+var UnknownScale func(model string, raw []byte)
+
+// sourceDecoders - decoders for unknown models by fromSource
+var sourceDecoders = map[int]ScaleDecoder{
+	1: decodeS400,           // Mi Fitness typed values
+	3: decodeEightElectrode, // string values with body composition
+}
+
+func findScaleDecoder(model string) ScaleDecoder {
+	if decoder, ok := scaleDecoders[model]; ok {
+		return decoder
+	}
+	for key, decoder := range scaleDecoders {
+		if prefix, ok := strings.CutSuffix(key, "*"); ok && strings.HasPrefix(model, prefix) {
+			return decoder
+		}
+	}
+	return nil
+}
+
+func decodeScale(record *ScaleRecord, raw []byte) (*core.Weight, error) {
+	decoder := findScaleDecoder(record.Model)
+	unknown := decoder == nil
+	if unknown {
+		if UnknownScale != nil {
+			UnknownScale(record.Model, raw)
+		}
+		if decoder = sourceDecoders[record.FromSource]; decoder == nil {
+			decoder = decodeAnyScale
+		}
+	}
+
+	w, err := decoder(record)
+	if err != nil {
+		return nil, err
+	}
+
+	if w == nil && !unknown && UnknownScale != nil {
+		UnknownScale(record.Model, raw)
+	}
+
+	return w, nil
+}
+
+// decodeS400 - Mi Fitness has typed values, other sources are decoded as any scales
+func decodeS400(v1 *ScaleRecord) (*core.Weight, error) {
+	if v1.FromSource != 1 {
+		return decodeAnyScale(v1)
+	}
+
+	var v2 struct {
+		Weight    float32 `json:"weight"` // 87.8 kg
+		BMI       float32 `json:"bmi"`    // 25.7 points
+		BodyFat   float32 `json:"bfp"`    // 22.9 %
+		BodyWater float32 `json:"bwp"`    // 58.8 %
+		BoneMass  float32 `json:"bmc"`    // 3.7 kg
+
+		MetabolicAge int     `json:"ma"`  // 55 years
+		MuscleMass   float32 `json:"slm"` // 63.9 kg
+		BodyType     int     `json:"bt"`  // 4
+		ProteinMass  float32 `json:"pm"`  // 11.6 kg
+		VisceralFat  int     `json:"vfl"` // 9 points
+
+		BMR                int     `json:"bmr"`        // 1832 kcal
+		BodyScore          int     `json:"sbc"`        // 80 points
+		HeartRate          int     `json:"heartRate"`  // 73 bpm
+		SkeletalMuscleMass float32 `json:"smm"`        // 37.6 kg
+		ReportFrom         string  `json:"reportFrom"` // Regular
+
+		//UserID             int     `json:"miid"`       // 1234567890
+		//Duid               int     `json:"duid"`       // 6 ?
+		//UserType           int     `json:"userType"`   // 1 ?
+		//Status             int     `json:"status"`     // 0 ?
+		//Time               int64   `json:"time"`       // 1755927448
+		//ProteinPercent     float32 `json:"pp"`         // 13.2 %
+		//IdealWeight        float32 `json:"swt"`        // 73.5 kg
+		//MuscleCorrection   float32 `json:"mc"`         // -5.2
+		//WeightCorrection   float32 `json:"wc"`         // -14.3
+		//FatCorrection      float32 `json:"fc"`         // -9.1
+		//WHR                float32 `json:"whr"`        // 1.3
+		//MusclePercent      float32 `json:"slp"`        // 72.9 %
+		//BoneMassPercentage float32 `json:"bmcp"`       // 4.2 %
+		//FatMass            float32 `json:"bfm"`        // 20.1 kg
+		//LeanBodyMass       float32 `json:"ffm"`        // 67.6 kg
+		//BodyWaterMass      float32 `json:"bwm"`        // 51.5 kg
+		BodyRes  float32 `json:"bodyRes"`  // 384.1
+		BodyRes2 float32 `json:"bodyRes2"` // 357.5
+		//Idx                int     `json:"idx"`        // -1
+		User struct {
+			//Uid              string `json:"uid"`
+			//Sex              string `json:"sex"`
+			//Birth            int64  `json:"birth"`
+			//AccountId        string `json:"accountId"`
+			//Icon             string `json:"icon"`
+			Name   string `json:"name"`
+			Height any    `json:"height"`
+			//Type             int    `json:"type"`
+			//AccountCode      int    `json:"accountCode"`
+			//CreationTime     int64  `json:"creationTime"`
+			//WeightTarget     string `json:"weightTarget"`
+			//WeightUpdateTime int64  `json:"weightUpdateTime"`
+			//UpdateTime       int64  `json:"updateTime"`
+		} `json:"user"`
+	}
+
+	if err := json.Unmarshal([]byte(v1.Data), &v2); err != nil {
+		return nil, err
+	}
+
+	// v2.Time has bugs. For "UserEditor" it has seconds, for Claimed it has milliseconds
+	w := &core.Weight{
+		Date:      time.UnixMilli(v1.CreateTime),
+		Weight:    v2.Weight,
+		BMI:       v2.BMI,
+		BodyFat:   v2.BodyFat,
+		BodyWater: v2.BodyWater,
+		BoneMass:  v2.BoneMass,
+
+		MetabolicAge:   v2.MetabolicAge,
+		MuscleMass:     v2.MuscleMass,
+		PhysiqueRating: v2.BodyType,
+		ProteinMass:    v2.ProteinMass,
+		VisceralFat:    v2.VisceralFat,
+
+		BasalMetabolism:    v2.BMR,
+		BodyScore:          v2.BodyScore,
+		HeartRate:          v2.HeartRate,
+		Height:             parseAnyFloat(v2.User.Height),
+		SkeletalMuscleMass: v2.SkeletalMuscleMass,
+
+		Impedance:     v2.BodyRes,
+		ImpedanceHigh: v2.BodyRes2,
+
+		User:   v2.User.Name,
+		Source: v2.ReportFrom,
+	}
+	return w, nil
+}
+
+// decodeEightElectrode - values are strings, body composition is in separate JSON string
+func decodeEightElectrode(v1 *ScaleRecord) (*core.Weight, error) {
+	if v1.FromSource != 3 {
+		return decodeAnyScale(v1)
+	}
+
+	var v2 struct {
+		BMI         string `json:"bmi"`
+		BodyRes     string `json:"bodyRes"`
+		BodyRes2    string `json:"bodyRes2"`
+		BodyResData string `json:"bodyResData"`
+		HeartRate   int    `json:"heartRate"`
+		Mid         string `json:"mid"`
+		Time        string `json:"time"`
+		User        struct {
+			//AccountId        int64   `json:"accountId"`
+			//Birth            int64   `json:"birth"`
+			//CreateTime       int64   `json:"createTime"`
+			//Height           int     `json:"height"`
+			//Icon             string  `json:"icon"`
+			//Id               int     `json:"id"`
+			Name string `json:"name"`
+			//Sex              int     `json:"sex"`
+			//Type             int     `json:"type"`
+			//UserId           int64   `json:"userId"`
+			//WeightTarget     float32 `json:"weightTarget"`
+			//WeightUpdateTime int     `json:"weightUpdateTime"`
+		} `json:"user"`
+		Weight string `json:"weight"`
+	}
+
+	if err := json.Unmarshal([]byte(v1.Data), &v2); err != nil {
+		return nil, err
+	}
+
+	w := &core.Weight{
+		Date:      time.UnixMilli(parseInt64(v2.Time)),
+		Weight:    parseFloat(v2.Weight),
+		BMI:       parseFloat(v2.BMI),
+		HeartRate: v2.HeartRate,
+		User:      v2.User.Name,
+		Source:    v1.Did,
+
+		Impedance:     parseFloat(v2.BodyRes),
+		ImpedanceHigh: parseFloat(v2.BodyRes2),
+	}
+
+	if v2.BodyResData != "" {
+		var v3 struct {
+			BodyFatRate        string `json:"bfp"`  // 12.1
+			MuscleMass         string `json:"slm"`  // 32.2
+			MoistureRate       string `json:"bwp"`  // 52.1
+			BoneMass           string `json:"bmc"`  // 1.6
+			VisceralFat        string `json:"vfl"`  // 5
+			ProteinRate        string `json:"pp"`   // 31
+			SkeletalMuscleMass string `json:"smm"`  // 15.39
+			BMI                string `json:"bmi"`  // 19.1
+			StandardWeightV2   string `json:"swt"`  // 46.2
+			MuscleControl      string `json:"mc"`   // 3.5
+			WeightControl      string `json:"wc"`   // 5.3
+			FatControl         string `json:"fc"`   // 2
+			WHR                string `json:"whr"`  // 1
+			Wl                 string `json:"wl"`   // 68.2
+			Hl                 string `json:"hl"`   // 70
+			BasalMetabolic     string `json:"bmr"`  // 1143
+			Bt                 string `json:"bt"`   // 1
+			BodyAge            string `json:"ma"`   // 14
+			BodyScore          string `json:"sbc"`  // 86
+			MuscleRate         string `json:"slp"`  // 84
+			BoneRate           string `json:"bmcp"` // 3.9
+			FatMass            string `json:"bfm"`  // 4.9
+			FatFreeBody        string `json:"ffm"`  // 35.8
+			BodyMoistureMass   string `json:"bwm"`  // 21.2
+			ProteinMass        string `json:"pm"`   // 12.6
+			Smi                string `json:"smi"`  // 7.2
+		}
+
+		if err := json.Unmarshal([]byte(v2.BodyResData), &v3); err != nil {
+			return nil, err
+		}
+
+		w.BodyFat = parseFloat(v3.BodyFatRate)
+		w.BodyWater = parseFloat(v3.MoistureRate)
+		w.BoneMass = parseFloat(v3.BoneMass)
+
+		w.MetabolicAge = parseInt(v3.BodyAge)
+		w.MuscleMass = parseFloat(v3.MuscleMass)
+		w.ProteinMass = parseFloat(v3.ProteinMass)
+		w.VisceralFat = parseInt(v3.VisceralFat)
+
+		w.BasalMetabolism = parseInt(v3.BasalMetabolic)
+		w.BodyScore = parseInt(v3.BodyScore)
+		w.SkeletalMuscleMass = parseFloat(v3.SkeletalMuscleMass)
+	}
+
+	return w, nil
+}
+
+// decodeAnyScale - values can be numbers or strings, zero weight means unsupported data
+func decodeAnyScale(v1 *ScaleRecord) (*core.Weight, error) {
+	var v2 struct {
+		Weight    any `json:"weight"`
+		BMI       any `json:"bmi"`
+		BodyFat   any `json:"bfp"`
+		BodyWater any `json:"bwp"`
+		BoneMass  any `json:"bmc"`
+
+		MetabolicAge any `json:"ma"`
+		MuscleMass   any `json:"slm"`
+		BodyType     any `json:"bt"`
+		ProteinMass  any `json:"pm"`
+		VisceralFat  any `json:"vfl"`
+
+		BMR                any `json:"bmr"`
+		BodyScore          any `json:"sbc"`
+		HeartRate          any `json:"heartRate"`
+		SkeletalMuscleMass any `json:"smm"`
+
+		User struct {
+			Name     string `json:"name"`
+			Height   any    `json:"height"`
+			DeviceID string `json:"deviceId"`
+		} `json:"user"`
+	}
+
+	if err := json.Unmarshal([]byte(v1.Data), &v2); err != nil {
+		return nil, err
+	}
+
+	// v2.Time has bugs. For "UserEditor" it has seconds, for Claimed it has milliseconds
+	w := &core.Weight{
+		Date:      time.UnixMilli(v1.CreateTime),
+		Weight:    parseAnyFloat(v2.Weight),
+		BMI:       parseAnyFloat(v2.BMI),
+		BodyFat:   parseAnyFloat(v2.BodyFat),
+		BodyWater: parseAnyFloat(v2.BodyWater),
+		BoneMass:  parseAnyFloat(v2.BoneMass),
+
+		MetabolicAge:   parseAnyInt(v2.MetabolicAge),
+		MuscleMass:     parseAnyFloat(v2.MuscleMass),
+		PhysiqueRating: parseAnyInt(v2.BodyType),
+		ProteinMass:    parseAnyFloat(v2.ProteinMass),
+		VisceralFat:    parseAnyInt(v2.VisceralFat),
+
+		BasalMetabolism:    parseAnyInt(v2.BMR),
+		BodyScore:          parseAnyInt(v2.BodyScore),
+		HeartRate:          parseAnyInt(v2.HeartRate),
+		Height:             parseAnyFloat(v2.User.Height),
+		SkeletalMuscleMass: parseAnyFloat(v2.SkeletalMuscleMass),
+
+		User:   v2.User.Name,
+		Source: v2.User.DeviceID,
+	}
+	if w.Weight == 0 {
+		return nil, nil
+	}
+	return w, nil
+}
+
+// decodeFitnessWeight - Mi Fitness weight value, scales model is detected by its own keys
+func decodeFitnessWeight(value string) (*core.Weight, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, err
+	}
+
+	if _, ok := keys["trunk_muscle_mass"]; ok {
+		return decodeEightElectrodeValue(value)
+	}
+
+	return decodeS400Value(value)
+}
+
+// decodeS400Value - Mi Body Composition Scale S400, also used for data without a model
+func decodeS400Value(value string) (*core.Weight, error) {
+	var v struct {
+		BasalMetabolism    int     `json:"basal_metabolism"`
+		BMI                float32 `json:"bmi"`
+		BodyAge            int     `json:"body_age"`
+		BodyFatRate        float32 `json:"body_fat_rate"`
+		BodyScore          int     `json:"body_score"`
+		BoneMass           float32 `json:"bone_mass"`
+		BPM                int     `json:"bpm"`
+		MoistureRate       float32 `json:"moisture_rate"`
+		MuscleMass         float32 `json:"muscle_mass"`
+		ProteinMass        float32 `json:"protein_mass"`
+		SkeletalMuscleMass float32 `json:"skeletal_muscle_mass"`
+		Time               int64   `json:"time"`
+		VisceralFat        float32 `json:"visceral_fat"`
+		Weight             float32 `json:"weight"`
+
+		//BodyMoistureMass float32 `json:"body_moisture_mass"`
+		//BoneRate         float32 `json:"bone_rate"`
+		//FatControl       float32 `json:"fat_control"`
+		//FatFreeBody      float32 `json:"fat_free_body"`
+		//MuscleControl    float32 `json:"muscle_control"`
+		//MuscleRate       float32 `json:"muscle_rate"`
+		//ProteinRate      float32 `json:"protein_rate"`
+		//ScoreStandardType  int     `json:"score_standard_type"`
+		//Somatotype       int     `json:"somatotype"`
+		//StandardWeight   int     `json:"standard_weight"`
+		//StandardWeightV2 float32 `json:"standard_weight_v2"`
+		//WeightControl    float32 `json:"weight_control"`
+		//Whr              float32 `json:"whr"`
+	}
+
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, err
+	}
+
+	return &core.Weight{
+		Date:      time.Unix(v.Time, 0), // 1732550224
+		Weight:    v.Weight,             // 69.8
+		BMI:       v.BMI,                // 23.6
+		BodyFat:   v.BodyFatRate,        // 19.6
+		BodyWater: v.MoistureRate,       // 51
+		BoneMass:  v.BoneMass,           // 2.8
+
+		MetabolicAge: v.BodyAge,    // 36
+		MuscleMass:   v.MuscleMass, // 53.3
+		ProteinMass:  v.ProteinMass,
+		VisceralFat:  int(v.VisceralFat),
+
+		BasalMetabolism:    v.BasalMetabolism,
+		BodyScore:          v.BodyScore,
+		HeartRate:          v.BPM,
+		SkeletalMuscleMass: v.SkeletalMuscleMass,
+	}, nil
+}
+
+// decodeEightElectrodeValue - Xiaomi Eight Electrode Body Composition Scale, has segmental data
+func decodeEightElectrodeValue(value string) (*core.Weight, error) {
+	var v struct {
+		BasalMetabolism int     `json:"basal_metabolism"` // 1632
+		BMI             float32 `json:"bmi"`              // 22.4
+		BodyAge         int     `json:"body_age"`         // 34
+		BodyFatRate     float32 `json:"body_fat_rate"`    // 12
+		BodyScore       int     `json:"body_score"`       // 90
+		BoneMass        float32 `json:"bone_mass"`        // 3.2
+		BPM             int     `json:"bpm"`              // 81
+		MoistureRate    float32 `json:"moisture_rate"`    // 65.3
+		MuscleMass      float32 `json:"muscle_mass"`      // 55.2
+		ProteinMass     float32 `json:"protein_mass"`     // 11.8
+		Time            int64   `json:"time"`             // 1743935472
+		VisceralFat     float32 `json:"visceral_fat"`     // 3
+		Weight          float32 `json:"weight"`           // 66.4
+
+		//BodyMoistureMass          float32 `json:"body_moisture_mass"`          // 43.4
+		//BodyShape                 int     `json:"body_shape"`                  // 4
+		//BoneRate                  float32 `json:"bone_rate"`                   // 4.8
+		//FatControl                float32 `json:"fat_control"`                 // 2.8
+		//FatMass                   float32 `json:"fat_mass"`                    // 8
+		//LimbsSkeletalMuscleIndex  float32 `json:"limbs_skeletal_muscle_index"` // 8.3
+		//MuscleControl             float32 `json:"muscle_control"`              // -5.4
+		//MuscleRate                float32 `json:"muscle_rate"`                 // 83.1
+		//ProteinRate               float32 `json:"protein_rate"`                // 17.8
+		//RecommendedCaloriesIntake int     `json:"recommended_calories_intake"` // 2366
+		//Somatotype                int     `json:"somatotype"`                  // 1
+		//StandardWeight            int     `json:"standard_weight"`             // 63
+		//StandardWeightV2          float32 `json:"standard_weight_v2"`          // 63.7
+		//TrunkFatMass              float32 `json:"trunk_fat_mass"`              // 4
+		//TrunkMuscleMass           float32 `json:"trunk_muscle_mass"`           // 25.1
+		//WeightControl             float32 `json:"weight_control"`              // -2.6
+		//Whr                       float32 `json:"whr"`                         // 0.8
+	}
+
+	if err := json.Unmarshal([]byte(value), &v); err != nil {
+		return nil, err
+	}
+
+	return &core.Weight{
+		Date:      time.Unix(v.Time, 0),
+		Weight:    v.Weight,
+		BMI:       v.BMI,
+		BodyFat:   v.BodyFatRate,
+		BodyWater: v.MoistureRate,
+		BoneMass:  v.BoneMass,
+
+		MetabolicAge: v.BodyAge,
+		MuscleMass:   v.MuscleMass,
+		ProteinMass:  v.ProteinMass,
+		VisceralFat:  int(v.VisceralFat),
+
+		BasalMetabolism: v.BasalMetabolism,
+		BodyScore:       v.BodyScore,
+		HeartRate:       v.BPM,
+	}, nil
+}
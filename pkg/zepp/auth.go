@@ -1,10 +1,14 @@
 package zepp
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
 	"github.com/AlexxIT/SmartScaleConnect/pkg/xiaomi"
 	"github.com/google/uuid"
 )
@@ -15,29 +19,105 @@ const paramsZeppLife = "_json=true&" +
 	"redirect_uri=https://api-mifit-cn.huami.com/huami.health.loginview.do&" +
 	"response_type=code"
 
-func (c *Client) Login(username, password string) error {
+const (
+	LoginXiaomi = "xiaomi" // Xiaomi account
+	LoginHuami  = "huami"  // Zepp (Amazfit, Huami) account with email
+)
+
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	if c.login == LoginHuami {
+		return c.loginHuami(ctx, username, password)
+	}
+
 	client := xiaomi.NewClient("")
-	code, err := client.OAuth2(paramsZeppLife, username, password)
+	client.DeviceID = c.xiaomiID
+	client.Challenges = c.Xiaomi
+	code, err := client.OAuth2(ctx, paramsZeppLife, username, password)
+	c.xiaomiID = client.DeviceID
 	if err != nil {
 		return err
 	}
 
 	// country CN is OK
-	form := fmt.Sprintf(
-		"app_name=com.xiaomi.hm.health&"+
-			"app_version=6.14.0&"+
-			"code=%s&"+
-			"country_code=CN&"+
-			"device_id=%s&"+
-			"device_model=phone&"+
-			"dn=api-mifit.zepp.com&"+
-			"grant_type=request_token&"+
-			"third_name=xiaomi-hm-mifit",
-		code, uuid.NewString(),
+	return c.clientLogin(ctx, url.Values{
+		"code":         {code},
+		"country_code": {"CN"},
+		"grant_type":   {"request_token"},
+		"third_name":   {"xiaomi-hm-mifit"},
+	})
+}
+
+// loginHuami - exchange email and password to access code
+func (c *Client) loginHuami(ctx context.Context, username, password string) error {
+	form := url.Values{
+		"client_id":    {"HuaMi"},
+		"country_code": {"US"},
+		"password":     {password},
+		"redirect_uri": {"https://s3-us-west-2.amazonaws.com/hm-registration/successsignin.html"},
+		"region":       {"us-west-2"},
+		"state":        {"REDIRECTION"},
+		"token":        {"access", "refresh"},
+	}
+
+	client := &http.Client{
+		Timeout: c.client.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := core.Post(
+		ctx, client, "https://api-user.huami.com/registrations/"+url.PathEscape(username)+"/tokens",
+		"application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
 	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
 
-	res, err := c.client.Post(
-		"https://account.zepp.com/v2/client/login", "application/x-www-form-urlencoded", strings.NewReader(form),
+	location, err := res.Location()
+	if err != nil {
+		return core.NewStatusError("zepp: login error: ", res)
+	}
+
+	query := location.Query()
+	if query.Has("error") {
+		return errors.New("zepp: login error: " + query.Get("error"))
+	}
+
+	code := query.Get("access")
+	if code == "" {
+		return errors.New("zepp: login error: can't find access code")
+	}
+
+	country := query.Get("country_code")
+	if country == "" {
+		country = "US"
+	}
+
+	return c.clientLogin(ctx, url.Values{
+		"code":         {code},
+		"country_code": {country},
+		"grant_type":   {"access_token"},
+		"third_name":   {"huami"},
+	})
+}
+
+// clientLogin - exchange access code to app token
+func (c *Client) clientLogin(ctx context.Context, form url.Values) error {
+	// same device for all logins, so the mobile app session stays alive
+	if c.deviceID == "" {
+		c.deviceID = uuid.NewString()
+	}
+
+	form.Set("app_name", "com.xiaomi.hm.health")
+	form.Set("app_version", "6.14.0")
+	form.Set("device_id", c.deviceID)
+	form.Set("device_model", "phone")
+	form.Set("dn", "api-mifit.zepp.com")
+
+	res, err := core.Post(
+		ctx, c.client, "https://account.zepp.com/v2/client/login", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()),
 	)
 	if err != nil {
 		return err
@@ -80,17 +160,91 @@ func (c *Client) Login(username, password string) error {
 		return err
 	}
 
+	if res1.TokenInfo.AppToken == "" {
+		return errors.New("zepp: login error: " + res1.Result)
+	}
+
 	c.appToken = res1.TokenInfo.AppToken
+	c.loginToken = res1.TokenInfo.LoginToken
 	c.userID = res1.TokenInfo.UserId
 
 	return nil
 }
 
-func (c *Client) LoginWithToken(token string) error {
-	c.userID, c.appToken, _ = strings.Cut(token, ":")
-	return c.GetFamilyMembers()
+// refreshAppToken - exchange long-lived login token to new app token without new login
+func (c *Client) refreshAppToken(ctx context.Context) error {
+	query := url.Values{
+		"app_name":    {"com.xiaomi.hm.health"},
+		"dn":          {"api-mifit.zepp.com"},
+		"login_token": {c.loginToken},
+		"os_version":  {"4.1.0"},
+	}
+
+	res, err := core.Get(ctx, c.client, "https://account.zepp.com/v1/client/app_tokens?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return core.NewStatusError("zepp: refresh token error: ", res)
+	}
+
+	var res1 struct {
+		TokenInfo struct {
+			AppToken string `json:"app_token"`
+			UserId   string `json:"user_id"`
+		} `json:"token_info"`
+		Result string `json:"result"`
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&res1); err != nil {
+		return err
+	}
+
+	if res1.TokenInfo.AppToken == "" {
+		return errors.New("zepp: refresh token error: " + res1.Result)
+	}
+
+	c.appToken = res1.TokenInfo.AppToken
+	if res1.TokenInfo.UserId != "" {
+		c.userID = res1.TokenInfo.UserId
+	}
+
+	return nil
+}
+
+// LoginWithToken - token format: userID:appToken[:loginToken:deviceID[:xiaomiID]]
+func (c *Client) LoginWithToken(ctx context.Context, token string) error {
+	fields := strings.Split(token, ":")
+	if len(fields) < 2 {
+		return errors.New("zepp: wrong token")
+	}
+
+	c.userID, c.appToken = fields[0], fields[1]
+	if len(fields) >= 4 {
+		c.loginToken, c.deviceID = fields[2], fields[3]
+	}
+	if len(fields) >= 5 {
+		c.xiaomiID = fields[4]
+	}
+
+	err := c.GetFamilyMembers(ctx)
+	if err == nil || c.loginToken == "" || !errors.Is(err, core.ErrUnauthorized) {
+		return err
+	}
+
+	if err = c.refreshAppToken(ctx); err != nil {
+		return err
+	}
+
+	return c.GetFamilyMembers(ctx)
 }
 
 func (c *Client) Token() string {
-	return c.userID + ":" + c.appToken
+	token := c.userID + ":" + c.appToken + ":" + c.loginToken + ":" + c.deviceID
+	if c.xiaomiID != "" {
+		token += ":" + c.xiaomiID
+	}
+	return token
 }
@@ -2,9 +2,11 @@ package zepp
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -12,29 +14,43 @@ import (
 	"time"
 
 	"github.com/AlexxIT/SmartScaleConnect/pkg/core"
+	"github.com/AlexxIT/SmartScaleConnect/pkg/xiaomi"
 )
 
 type Client struct {
 	client *http.Client
+	login  string
 
-	appToken string // for auth
-	userID   string // for some requests
+	appToken   string // for auth
+	userID     string // for some requests
+	loginToken string // for app token refresh
+	deviceID   string // same for all logins
+	xiaomiID   string // device ID for Xiaomi account login, so verified device stays trusted
 
-	family map[string]int64
+	family map[string]*Member
+
+	Xiaomi xiaomi.Challenges // for Xiaomi account login
+}
+
+type Member struct {
+	ID       int64
+	Nickname string
+	Height   int // cm
 }
 
-func NewClient() *Client {
+func NewClient(login string) *Client {
 	return &Client{
 		client: &http.Client{Timeout: time.Minute},
+		login:  login,
 	}
 }
 
-func (c *Client) GetAllWeights() ([]*core.Weight, error) {
-	return c.GetFilterWeights("")
+func (c *Client) GetAllWeights(ctx context.Context) ([]*core.Weight, error) {
+	return c.GetFilterWeights(ctx, "")
 }
 
-func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
-	familyID, err := c.GetFamilyID(name)
+func (c *Client) GetFilterWeights(ctx context.Context, name string) ([]*core.Weight, error) {
+	familyID, err := c.GetFamilyID(ctx, name)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +64,7 @@ func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
 			c.userID, familyID, ts,
 		)
 
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -61,6 +77,10 @@ func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
 		}
 		defer res.Body.Close()
 
+		if res.StatusCode != http.StatusOK {
+			return nil, core.NewStatusError("zepp: ", res)
+		}
+
 		var res1 struct {
 			Items []Record `json:"items"`
 			Next  int64    `json:"next"`
@@ -94,6 +114,8 @@ func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
 				BodyScore:       record.Summary.BodyScore,
 				Height:          record.Summary.Height,
 
+				Impedance: float32(record.Summary.Impedance),
+
 				User:   name,
 				Source: record.DeviceId,
 			}
@@ -106,27 +128,51 @@ func (c *Client) GetFilterWeights(name string) ([]*core.Weight, error) {
 	return weights, nil
 }
 
-func (c *Client) GetFamilyID(name string) (int64, error) {
+func (c *Client) GetFamilyID(ctx context.Context, name string) (int64, error) {
 	if name == "" {
 		return -1, nil
 	}
 
+	member, err := c.GetFamilyMember(ctx, name, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return member.ID, nil
+}
+
+// GetFamilyMember returns member by nickname, empty name is the main user.
+// Missing member is created only if it has params in the create list.
+func (c *Client) GetFamilyMember(ctx context.Context, name string, create map[string]*core.Member) (*Member, error) {
+	if name == "" {
+		return &Member{ID: -1}, nil
+	}
+
 	if c.family == nil {
-		if err := c.GetFamilyMembers(); err != nil {
-			return 0, err
+		if err := c.GetFamilyMembers(ctx); err != nil {
+			return nil, err
 		}
 	}
 
-	if fid, ok := c.family[name]; ok {
-		return fid, nil
+	if member, ok := c.family[name]; ok {
+		return member, nil
+	}
+
+	if params, ok := create[name]; ok {
+		if err := c.AddFamilyMember(ctx, name, params); err != nil {
+			return nil, err
+		}
+		if member, ok := c.family[name]; ok {
+			return member, nil
+		}
 	}
 
-	return 0, errors.New("zepp: can't find family member: " + name)
+	return nil, errors.New("zepp: can't find family member: " + name)
 }
 
-func (c *Client) GetFamilyMembers() error {
-	req, err := http.NewRequest(
-		"POST", "https://api-mifit.zepp.com/huami.health.scale.familymember.get.json",
+func (c *Client) GetFamilyMembers(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", "https://api-mifit.zepp.com/huami.health.scale.familymember.get.json",
 		strings.NewReader("fuid=all&userid="+c.userID),
 	)
 	if err != nil {
@@ -143,7 +189,7 @@ func (c *Client) GetFamilyMembers() error {
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("zepp: " + res.Status)
+		return core.NewStatusError("zepp: ", res)
 	}
 
 	var res1 struct {
@@ -172,37 +218,95 @@ func (c *Client) GetFamilyMembers() error {
 		return err
 	}
 
-	c.family = make(map[string]int64)
+	c.family = make(map[string]*Member)
 	for _, item := range res1.Data.List {
-		c.family[item.Nickname] = item.Fuid
+		c.family[item.Nickname] = &Member{ID: item.Fuid, Nickname: item.Nickname, Height: item.Height}
 	}
 
 	return nil
 }
 
-func (c *Client) AddWeights(weights []*core.Weight) error {
+// AddFamilyMember creates new family member and reloads members list.
+// Params are required because Zepp uses them for BMI and body composition.
+func (c *Client) AddFamilyMember(ctx context.Context, name string, params *core.Member) error {
+	if params == nil || params.Height == 0 || params.Birthday == "" {
+		return errors.New("zepp: family member needs height, birthday and gender: " + name)
+	}
+
+	if _, err := time.Parse("2006-01", params.Birthday); err != nil {
+		return errors.New("zepp: wrong birthday format, should be YYYY-MM: " + name)
+	}
+
+	var gender string
+	switch params.Gender {
+	case "male":
+		gender = "1"
+	case "female":
+		gender = "0"
+	default:
+		return errors.New("zepp: wrong gender, should be male or female: " + name)
+	}
+
+	form := url.Values{
+		"userid":   {c.userID},
+		"nickname": {name},
+		"brithday": {params.Birthday}, // Zepp API typo
+		"gender":   {gender},
+		"height":   {strconv.Itoa(params.Height)},
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", "https://api-mifit.zepp.com/huami.health.scale.familymember.add.json",
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("apptoken", c.appToken)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return core.NewStatusError("zepp: add family member error: ", res)
+	}
+
+	return c.GetFamilyMembers(ctx)
+}
+
+func (c *Client) AddWeights(ctx context.Context, weights []*core.Weight, opts core.Options) error {
 	if len(weights) == 0 {
 		return nil
 	}
 
 	var records []*Record
 	for _, weight := range weights {
-		familyID, err := c.GetFamilyID(weight.User)
+		member, err := c.GetFamilyMember(ctx, weight.User, opts.Members)
 		if err != nil {
 			return err
 		}
 
+		height := weight.Height
+		if height == 0 {
+			height = float32(member.Height)
+		}
+
 		r := &Record{
 			DataSource:    dataSource,
 			DeviceId:      weight.Source,
 			DeviceSource:  deviceSource,
 			GeneratedTime: weight.Date.Unix(),
-			MemberId:      strconv.FormatInt(familyID, 10),
+			MemberId:      strconv.FormatInt(member.ID, 10),
 			UserId:        c.userID,
 			WeightType:    0,
 			Summary: RecordSummary{
 				Weight:        weight.Weight,
-				Height:        weight.Height,
+				Height:        height,
 				BMI:           weight.BMI,
 				FatRate:       weight.BodyFat,
 				BodyWaterRate: weight.BodyWater,
@@ -218,10 +322,14 @@ func (c *Client) AddWeights(weights []*core.Weight) error {
 				Source:        source,
 
 				//StandBodyWeight:  64.4,
-				//Impedance:        482,
-				//EncryptImpedance: "482",
 			},
 		}
+
+		// Zepp Life calculates body composition from impedance by itself
+		if impedance := int(math.Round(float64(weight.Impedance))); impedance > 0 {
+			r.Summary.Impedance = impedance
+			r.Summary.EncryptImpedance = strconv.Itoa(impedance)
+		}
 		records = append(records, r)
 	}
 
@@ -230,8 +338,8 @@ func (c *Client) AddWeights(weights []*core.Weight) error {
 		return err
 	}
 
-	req, err := http.NewRequest(
-		"POST", "https://api-mifit.zepp.com/users/"+c.userID+"/members/-1/weightRecords", bytes.NewReader(body),
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", "https://api-mifit.zepp.com/users/"+c.userID+"/members/-1/weightRecords", bytes.NewReader(body),
 	)
 	if err != nil {
 		return err
@@ -247,14 +355,14 @@ func (c *Client) AddWeights(weights []*core.Weight) error {
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("zepp: add weights error: " + res.Status)
+		return core.NewStatusError("zepp: add weights error: ", res)
 	}
 
 	return nil
 }
 
-func (c *Client) DeleteWeight(weight *core.Weight) error {
-	familyID, err := c.GetFamilyID(weight.User)
+func (c *Client) DeleteWeight(ctx context.Context, weight *core.Weight, _ core.Options) error {
+	familyID, err := c.GetFamilyID(ctx, weight.User)
 	if err != nil {
 		return err
 	}
@@ -262,8 +370,8 @@ func (c *Client) DeleteWeight(weight *core.Weight) error {
 	data := fmt.Sprintf(`[{"ts":%d,"fuid":"%d"}]`, weight.Date.Unix(), familyID)
 
 	form := url.Values{"dt": {"1"}, "jsondata": {data}, "userid": {c.userID}}
-	req, err := http.NewRequest(
-		"POST", "https://api-mifit.zepp.com/huami.health.scale.delete.json", strings.NewReader(form.Encode()),
+	req, err := http.NewRequestWithContext(
+		ctx, "POST", "https://api-mifit.zepp.com/huami.health.scale.delete.json", strings.NewReader(form.Encode()),
 	)
 	if err != nil {
 		return err
@@ -279,12 +387,13 @@ func (c *Client) DeleteWeight(weight *core.Weight) error {
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("zepp: delete weight error" + res.Status)
+		return core.NewStatusError("zepp: delete weight error: ", res)
 	}
 
 	return nil
 }
 
+// Equal compares source weighing w1 with uploaded w2, empty source height is filled on upload
 func (c *Client) Equal(w1, w2 *core.Weight) bool {
 	return equalFloat(w1.Weight, w2.Weight) &&
 		equalFloat(w1.BMI, w2.BMI) &&
@@ -297,7 +406,8 @@ func (c *Client) Equal(w1, w2 *core.Weight) bool {
 		w1.VisceralFat == w2.VisceralFat &&
 		w1.BasalMetabolism == w2.BasalMetabolism &&
 		w1.BodyScore == w2.BodyScore &&
-		equalFloat(w1.Height, w2.Height)
+		(w1.Height == 0 || equalFloat(w1.Height, w2.Height)) &&
+		math.Round(float64(w1.Impedance)) == math.Round(float64(w2.Impedance))
 }
 
 const (